@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 DOC_VERSION_PREFIX - When an off-chain document is anchored again, the metadata of the version it replaces is kept
+//						  at DOC_VERSION_PREFIX<assetID>~<docId>~<version, zero padded>.
+//==============================================================================================================================
+const DOC_VERSION_PREFIX = "docversion~"
+
+//==============================================================================================================================
+//	 sha256_pattern - A hex encoded SHA-256.
+//==============================================================================================================================
+var sha256_pattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	HashMatch - A document version whose hash matched the one passed to verifyDoc. Current is false if the document
+//				has been anchored again since.
+//==============================================================================================================================
+type HashMatch struct {
+	DocId      string `json:"docId"`
+	Version    int    `json:"version"`
+	TxID       string `json:"txnid"`
+	UploadedAt string `json:"uploadedAt"`
+	Current    bool   `json:"current"`
+}
+
+//==============================================================================================================================
+//	 doc_version_key - Returns the key of an earlier version of a document.
+//==============================================================================================================================
+func doc_version_key(v5cID string, docId string, version int) string {
+
+	return fmt.Sprintf("%v%v~%v~%06d", DOC_VERSION_PREFIX, v5cID, docId, version)
+}
+
+//==============================================================================================================================
+//	 retrieve_doc_versions - Gets every version of the document passed, oldest first, ending with d itself.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_doc_versions(stub shim.ChaincodeStubInterface, d Document) ([]Document, error) {
+
+	versions := []Document{}
+
+	for n := 1; n < d.Version; n++ {
+
+		bytes, err := stub.GetState(doc_version_key(d.AssetId, d.DocId, n))
+
+		if err != nil || bytes == nil {
+			return nil, errors.New(fmt.Sprintf("Unable to get version %v of document %v", n, d.DocId))
+		}
+
+		var old Document
+
+		err = json.Unmarshal(bytes, &old)
+
+		if err != nil {
+			return nil, errors.New("Corrupt document record " + string(bytes))
+		}
+
+		versions = append(versions, old)
+	}
+
+	return append(versions, d), nil
+}
+
+//=================================================================================================================================
+//	 anchorDoc - Anchors a document kept off-chain by its SHA-256, URI and metadata. Passing the docId of an anchored
+//				 document records a new version of it; earlier versions stay verifiable.
+//=================================================================================================================================
+func (t *SimpleChaincode) anchorDoc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	doc.Hash = strings.ToLower(doc.Hash)
+
+	if !sha256_pattern.MatchString(doc.Hash) {
+		return nil, new_error(ERR_INVALID_INPUT, "Invalid sha256 "+doc.Hash+", expected 64 hex digits").on_field("doc.sha256")
+	}
+
+	if doc.URI == "" {
+		return nil, new_error(ERR_INVALID_INPUT, "anchorDoc needs the uri the document is kept at").on_field("doc.uri")
+	}
+
+	var previous Document
+
+	if doc.DocId != "" {
+
+		var err error
+
+		previous, err = t.retrieve_doc(stub, v.V5cID, doc.DocId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if previous.Storage != DOC_OFFCHAIN {
+			return nil, new_error(ERR_INVALID_STATE, "Document "+doc.DocId+" is not an off-chain document").on_field("doc.docId")
+		}
+
+		if previous.Uploader != caller && v.OwnerId != caller {
+			return nil, new_error(ERR_PERMISSION_DENIED, "Permission denied. anchorDoc only "+previous.Uploader+" or the owner may anchor a new version of "+doc.DocId)
+		}
+
+		doc.DocType = previous.DocType
+	}
+
+	if _, ok := find_doc_type(doc.DocType); !ok {
+		return nil, new_error(ERR_INVALID_INPUT, fmt.Sprintf("Invalid docType %v, expected one of %v, %v, %v", doc.DocType, DOC_DELIVERY_CERTIFICATE, DOC_TEST_REPORT, DOC_INVOICE)).on_field("doc.docType")
+	}
+
+	if !may_upload(doc.DocType, v, caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. anchorDoc caller:%v role:%v may not upload documents of type %v", caller, caller_affiliation, doc.DocType))
+	}
+
+	var d Document
+	var err error
+
+	if doc.DocId == "" {
+
+		d, err = t.new_doc(stub, v, caller, caller_affiliation, doc, doc.Hash, doc.Size)
+
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+
+		bytes, err := json.Marshal(previous)
+
+		if err != nil {
+			return nil, errors.New("Error converting document record")
+		}
+
+		err = stub.PutState(doc_version_key(v.V5cID, previous.DocId, previous.Version), bytes)
+
+		if err != nil {
+			return nil, errors.New("Error storing document version")
+		}
+
+		now, err := t.tx_time(stub)
+
+		if err != nil {
+			return nil, err
+		}
+
+		d = previous
+		d.Hash = doc.Hash
+		d.Size = doc.Size
+		d.Uploader = caller
+		d.UploaderRole = caller_affiliation
+		d.UploadedAt = now.Format(time.RFC3339)
+		d.TxID = stub.GetTxID()
+		d.Version = previous.Version + 1
+
+		if doc.FileName != "" {
+			d.FileName = doc.FileName
+		}
+
+		if doc.MimeType != "" {
+			d.MimeType = doc.MimeType
+		}
+	}
+
+	d.Storage = DOC_OFFCHAIN
+	d.URI = doc.URI
+
+	err = t.record_doc(stub, v, d, caller, "anchorDoc")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(d)
+}
+
+//=================================================================================================================================
+//	 verifyDoc - Looks the SHA-256 passed up among every version of the asset's documents, or of one document if a
+//				 docId is passed. Match is true if it is the hash of a current version. Documents the caller may not
+//				 read are left out.
+//=================================================================================================================================
+func (t *SimpleChaincode) verifyDoc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	hash := strings.ToLower(doc.Hash)
+
+	if !sha256_pattern.MatchString(hash) {
+		return nil, new_error(ERR_INVALID_INPUT, "Invalid sha256 "+doc.Hash+", expected 64 hex digits").on_field("doc.sha256")
+	}
+
+	docs, err := t.retrieve_docs(stub, v.V5cID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	match := false
+	matches := []HashMatch{}
+
+	for _, d := range docs {
+
+		if doc.DocId != "" && d.DocId != doc.DocId {
+			continue
+		}
+
+		if !may_read_doc(d, v, caller, caller_affiliation) {
+			continue
+		}
+
+		versions, err := t.retrieve_doc_versions(stub, d)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, version := range versions {
+
+			if version.Hash != hash {
+				continue
+			}
+
+			current := version.Version == d.Version
+
+			matches = append(matches, HashMatch{DocId: d.DocId, Version: version.Version, TxID: version.TxID, UploadedAt: version.UploadedAt, Current: current})
+
+			match = match || current
+		}
+	}
+
+	bytes, err := json.Marshal(struct {
+		AssetId string      `json:"assetID"`
+		Hash    string      `json:"sha256"`
+		Match   bool        `json:"match"`
+		Matches []HashMatch `json:"matches"`
+	}{v.V5cID, hash, match, matches})
+
+	if err != nil {
+		return nil, errors.New("VERIFYDOC: Error converting result")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 MAX_BATCH_SIZE - The most items createAssets or applyExternalUpdates take in one transaction. maxBatchSize of the
+//					  configuration may lower it.
+//==============================================================================================================================
+const MAX_BATCH_SIZE = 500
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	BatchItem - One object of the "assets" array of createAssets, shaped like the argument of createAsset.
+//==============================================================================================================================
+type BatchItem struct {
+	Asset Animal `json:"asset"`
+}
+
+//==============================================================================================================================
+//	ItemReport - The outcome of one item of a batch. Index is its position in the "assets" array.
+//==============================================================================================================================
+type ItemReport struct {
+	Index   int    `json:"index"`
+	AssetId string `json:"assetID"`
+	Status  string `json:"status"`
+}
+
+//==============================================================================================================================
+//	BatchReport - The response of createAssets.
+//==============================================================================================================================
+type BatchReport struct {
+	Created int          `json:"created"`
+	Items   []ItemReport `json:"items"`
+}
+
+//==============================================================================================================================
+//	 item_errors - Returns the field errors of err, a check of the item at prefix, e.g. "assets[2].", that failed,
+//				   with the paths of the item's fields prefixed. Checks failing with a single error report it with its
+//				   code as rule.
+//==============================================================================================================================
+func item_errors(prefix string, err ChaincodeError) []FieldError {
+
+	if len(err.Errors) == 0 {
+
+		field := err.Field
+
+		if field == "" {
+			field = "asset"
+		}
+
+		return []FieldError{{Field: prefix + field, Rule: err.Code, Message: err.Message}}
+	}
+
+	errs := []FieldError{}
+
+	for _, e := range err.Errors {
+		e.Field = prefix + e.Field
+		e.Message = strings.Replace(e.Message, "asset.", prefix+"asset.", -1)
+		errs = append(errs, e)
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 batch_keys - Returns the keys no two items of a batch may share: the asset ID and the unique business keys set,
+//				  each as <field>~<value>.
+//==============================================================================================================================
+func batch_keys(v Vehicle) []string {
+
+	keys := []string{"assetID~" + v.V5cID}
+
+	for _, index := range lookup_indexes {
+
+		f, _ := find_field(index.Field)
+
+		if value := *f.Value(&v); index.Unique && value != "" {
+			keys = append(keys, index.Field+"~"+value)
+		}
+	}
+
+	return keys
+}
+
+//==============================================================================================================================
+//	 batch_errors - Returns the errors of the item at prefix whose keys, see batch_keys, were seen on an earlier item.
+//					seen maps the keys seen to the index of their item.
+//==============================================================================================================================
+func batch_errors(prefix string, v Vehicle, seen map[string]int) []FieldError {
+
+	errs := []FieldError{}
+
+	for _, key := range batch_keys(v) {
+
+		i, ok := seen[key]
+
+		if !ok {
+			continue
+		}
+
+		parts := strings.SplitN(key, "~", 2)
+		rule := ERR_DUPLICATE_KEY
+
+		if parts[0] == "assetID" {
+			rule = ERR_ALREADY_EXISTS
+		}
+
+		errs = append(errs, FieldError{Field: prefix + "asset." + parts[0], Rule: rule, Message: fmt.Sprintf("%v %v is used by assets[%v] already", parts[0], parts[1], i)})
+	}
+
+	return errs
+}
+
+//=================================================================================================================================
+//	 createAssets - Creates every asset of the batch passed, or none. Each item goes through the checks of createAsset
+//					and is checked against the items before it before anything is written; if any item fails, the
+//					error lists the errors of every item under its index. The asset index is written once the
+//					records are stored, and the batch is announced in one event. Items without an assetID get the
+//					next IDs of the sequence if the deployment allocates them; the report lists every item's ID.
+//=================================================================================================================================
+func (t *SimpleChaincode) createAssets(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, items []BatchItem) ([]byte, error) {
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) > settings.MaxBatchSize {
+		return nil, new_error(ERR_TOO_LARGE, fmt.Sprintf("createAssets takes at most %v assets at once", settings.MaxBatchSize)).on_field("assets")
+	}
+
+	seq, err := t.retrieve_id_sequence(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := map[string]bool{}
+
+	for _, item := range items {
+		reserved[item.Asset.AssetId] = true
+	}
+
+	assets := []Vehicle{}
+	errs := []FieldError{}
+	seen := map[string]int{}
+	invalid, allocated := 0, 0
+
+	for i, item := range items {
+
+		prefix := fmt.Sprintf("assets[%v].", i)
+
+		item.Asset.normalise_dates()
+
+		v5cID, err := t.resolve_asset_id(stub, settings, item.Asset.AssetId, &seq, reserved)
+
+		if err != nil {
+
+			e, ok := err.(ChaincodeError)
+
+			if !ok {
+				return nil, err
+			}
+
+			invalid++
+			errs = append(errs, item_errors(prefix, e)...)
+			continue
+		}
+
+		if v5cID != item.Asset.AssetId {
+			allocated++
+		}
+
+		def, err := t.asset_definition(stub, item.Asset.AssetType)
+
+		if err != nil {
+
+			e, ok := err.(ChaincodeError)
+
+			if !ok {
+				return nil, err
+			}
+
+			invalid++
+			errs = append(errs, item_errors(prefix, e)...)
+			continue
+		}
+
+		v := new_asset(v5cID, caller, item.Asset, def)
+
+		item_errs := batch_errors(prefix, v, seen)
+
+		err = t.check_new_asset(stub, v, item.Asset, def, "createAssets")
+
+		if err != nil {
+
+			e, ok := err.(ChaincodeError)
+
+			if !ok {
+				return nil, err
+			}
+
+			item_errs = append(item_errs, item_errors(prefix, e)...)
+		}
+
+		if len(item_errs) > 0 {
+			invalid++
+			errs = append(errs, item_errs...)
+		}
+
+		for _, key := range batch_keys(v) {
+			if _, ok := seen[key]; !ok {
+				seen[key] = i
+			}
+		}
+
+		assets = append(assets, v)
+	}
+
+	if invalid > 0 {
+		return nil, invalid_input("createAssets", fmt.Sprintf("%v of %v assets are invalid, none were created", invalid, len(items)), errs)
+	}
+
+	report := BatchReport{Items: []ItemReport{}}
+	v5cIDs := []string{}
+
+	for i, v := range assets {
+
+		_, _, err := t.store_changes(stub, v, caller, "createAssets", "")
+
+		if err != nil {
+			return nil, wrap_error("Error saving asset "+v.V5cID, err)
+		}
+
+		report.Items = append(report.Items, ItemReport{Index: i, AssetId: v.V5cID, Status: "created"})
+		v5cIDs = append(v5cIDs, v.V5cID)
+	}
+
+	created, err := t.tx_time(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v5cID := range v5cIDs {
+
+		err = t.add_asset_index(stub, v5cID, created.Format(time.RFC3339))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if allocated > 0 {
+
+		err = t.save_id_sequence(stub, seq)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = t.emit_batch_event(stub, EVENT_ASSETS_CREATED, v5cIDs, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report.Created = len(v5cIDs)
+
+	bytes, err := json.Marshal(report)
+
+	if err != nil {
+		return nil, errors.New("CREATEASSETS: Error converting report")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Asset types - Trucks follow the supply chain of lifecycle.go, cars the lease lifecycle below. Both share the
+//				   asset index, the history, the documents and the permission matrix.
+//==============================================================================================================================
+const ASSET_TRUCK = "truck"
+const ASSET_CAR = "car"
+
+//==============================================================================================================================
+//	 Car status types - The car lifecycle: a template created by the regulator is built by a manufacturer, owned
+//						privately or by a lease company and finally scrapped.
+//==============================================================================================================================
+const CAR_TEMPLATE = "TEMPLATE"
+const CAR_MANUFACTURE = "MANUFACTURE"
+const CAR_PRIVATE_OWNERSHIP = "PRIVATE_OWNERSHIP"
+const CAR_LEASED_OUT = "LEASED_OUT"
+const CAR_BEING_SCRAPPED = "BEING_SCRAPPED"
+const CAR_SCRAPPED = "SCRAPPED"
+
+//==============================================================================================================================
+//	 car_statuses - The car statuses by the numbers car records of the legacy schema hold, see STATE_TEMPLATE etc.
+//==============================================================================================================================
+var car_statuses = []string{CAR_TEMPLATE, CAR_MANUFACTURE, CAR_PRIVATE_OWNERSHIP, CAR_LEASED_OUT, CAR_BEING_SCRAPPED}
+
+//==============================================================================================================================
+//	 type_fields - The fields only assets of one type carry. Fields not listed, e.g. ownerId, belong to every type.
+//==============================================================================================================================
+var type_fields = map[string][]string{
+	ASSET_TRUCK: {"matnrAf", "poDma", "poSupp", "dmaDelDate", "afDelDate", "truckMod", "truckPdate", "truckChnum", "truckEnnum",
+		"suppTest", "grDma", "grAf", "dmaMasdat", "afDmaTest", "dmaDelCert"},
+	ASSET_CAR: {"make", "model", "reg", "VIN", "colour"},
+}
+
+//==============================================================================================================================
+//	 car_lifecycle - The moves of the car lifecycle, each made by the owner through the function named. The regulator
+//					 hands a template to a manufacturer, who sells the finished car to a private owner. Private
+//					 owners sell to each other, lease to lease companies and finally hand the car to a scrap merchant,
+//					 who scraps it.
+//==============================================================================================================================
+var car_lifecycle = []Transition{
+	{Function: "authority_to_manufacturer", From: CAR_TEMPLATE, To: CAR_MANUFACTURE, Roles: []string{REGULATOR}, Recipient: MANUFACTURER},
+	{Function: "manufacturer_to_private", From: CAR_MANUFACTURE, To: CAR_PRIVATE_OWNERSHIP, Roles: []string{MANUFACTURER}, Recipient: PRIVATE_ENTITY, Required: []string{"make", "model", "reg", "VIN", "colour"}},
+	{Function: "private_to_private", From: CAR_PRIVATE_OWNERSHIP, To: CAR_PRIVATE_OWNERSHIP, Roles: []string{PRIVATE_ENTITY}, Recipient: PRIVATE_ENTITY},
+	{Function: "private_to_lease_company", From: CAR_PRIVATE_OWNERSHIP, To: CAR_LEASED_OUT, Roles: []string{PRIVATE_ENTITY}, Recipient: LEASE_COMPANY},
+	{Function: "lease_company_to_private", From: CAR_LEASED_OUT, To: CAR_PRIVATE_OWNERSHIP, Roles: []string{LEASE_COMPANY}, Recipient: PRIVATE_ENTITY},
+	{Function: "private_to_scrap_merchant", From: CAR_PRIVATE_OWNERSHIP, To: CAR_BEING_SCRAPPED, Roles: []string{PRIVATE_ENTITY}, Recipient: SCRAP_MERCHANT},
+	{Function: "scrap_vehicle", From: CAR_BEING_SCRAPPED, To: CAR_SCRAPPED, Roles: []string{SCRAP_MERCHANT}},
+}
+
+//==============================================================================================================================
+//	 asset_type - Returns the type of the asset. Records written before cars came back are trucks.
+//==============================================================================================================================
+func (v Vehicle) asset_type() string {
+
+	if v.AssetType == "" {
+		return ASSET_TRUCK
+	}
+
+	return v.AssetType
+}
+
+//==============================================================================================================================
+//	 new_car - Returns the car createAsset makes of the request passed. Like create_vehicle made them, it starts as a
+//			   template owned by its creator unless the request names an owner.
+//==============================================================================================================================
+func new_car(v5cID string, caller string, animals Animal) Vehicle {
+
+	v := Vehicle{
+		V5cID:           v5cID,
+		AssetId:         v5cID,
+		AssetType:       ASSET_CAR,
+		TransactionType: animals.TransactionType,
+		OwnerId:         animals.OwnerId,
+		Make:            animals.Make,
+		Model:           animals.Model,
+		Reg:             animals.Reg,
+		VIN:             animals.VIN,
+		Colour:          animals.Colour,
+		Status:          CAR_TEMPLATE,
+	}
+
+	if v.OwnerId == "" {
+		v.OwnerId = caller
+	}
+
+	return v
+}
+
+//==============================================================================================================================
+//	 foreign_fields - Returns an error for each field set on v that assets of the type passed do not carry.
+//==============================================================================================================================
+func foreign_fields(asset_type string, v Vehicle) []FieldError {
+
+	errs := []FieldError{}
+
+	for _, other := range sorted_keys(type_fields) {
+
+		if other == asset_type {
+			continue
+		}
+
+		for _, name := range type_fields[other] {
+			if f, _ := find_field(name); *f.Value(&v) != "" {
+				errs = append(errs, FieldError{Field: "asset." + name, Rule: RULE_UNKNOWN, Message: "asset." + name + " is not a field of " + asset_type + " assets"})
+			}
+		}
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 find_car_move - Looks up the move of the car lifecycle made by the function named.
+//==============================================================================================================================
+func find_car_move(function string) (Transition, bool) {
+
+	for _, tr := range car_lifecycle {
+		if tr.Function == function {
+			return tr, true
+		}
+	}
+
+	return Transition{}, false
+}
+
+//=================================================================================================================================
+//	 move_car - Makes the move of the car lifecycle of the function named. The caller must own the car and hold the
+//				role of the move; moves handing the car over name the recipient and its role in the "transfer"
+//				object, and the recipient's role must be the one the move hands the car to.
+//=================================================================================================================================
+func (t *SimpleChaincode) move_car(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, function string, transfer TransferRequest) ([]byte, error) {
+
+	tr, ok := find_car_move(function)
+
+	if !ok {
+		return nil, new_error(ERR_INVALID_INPUT, function+" is not a move of the car lifecycle").on_asset(v.V5cID)
+	}
+
+	if v.asset_type() != ASSET_CAR {
+		return nil, new_error(ERR_INVALID_STATE, fmt.Sprintf("Invalid transition. %v asset %v is a %v, not a car", function, v.V5cID, v.asset_type())).on_asset(v.V5cID)
+	}
+
+	if asset_status(v) != tr.From {
+		return nil, new_error(ERR_INVALID_STATE, fmt.Sprintf("Invalid transition. %v asset %v is %v, expected %v", function, v.V5cID, asset_status(v), tr.From)).on_asset(v.V5cID)
+	}
+
+	if v.OwnerId != caller || !contains(tr.Roles, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission Denied. %v may only be made by the owner as %v, owner:%v caller:%v role:%v", function, tr.Roles, v.OwnerId, caller, caller_affiliation))
+	}
+
+	if tr.Recipient != "" {
+
+		settings, err := t.retrieve_settings(stub)
+
+		if err != nil {
+			return nil, err
+		}
+
+		role, ok := settings.participant_role(transfer.RecipientRole)
+
+		if !ok || role != tr.Recipient {
+			return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission Denied. %v hands the car to a %v, not to %v", function, tr.Recipient, transfer.RecipientRole)).on_field("transfer.recipientRole")
+		}
+
+		if transfer.Recipient == caller {
+			return nil, new_error(ERR_INVALID_INPUT, "The recipient already owns asset "+v.V5cID).on_field("transfer.recipient")
+		}
+
+		v.OwnerId = transfer.Recipient
+	}
+
+	missing := missing_fields(v, tr.Required)
+
+	if len(missing) > 0 {
+		return nil, new_error(ERR_INVALID_INPUT, fmt.Sprintf("Car not fully defined. %v requires fields: %v", function, strings.Join(missing, ", ")))
+	}
+
+	v.Status = tr.To
+	v.Scrapped = tr.To == CAR_SCRAPPED
+	v.Caller = caller
+
+	_, err := t.save_changes(stub, v, caller, function)
+
+	if err != nil {
+		return nil, wrap_error("Error saving changes", err)
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 update_car - Writes the car fields of the request as updateAsset does, for update_make, update_vin etc. of the
+//				  car chaincode. The permission matrix holds their rules.
+//=================================================================================================================================
+func (t *SimpleChaincode) update_car(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, animals Animal, function string) ([]byte, error) {
+
+	if v.asset_type() != ASSET_CAR {
+		return nil, new_error(ERR_INVALID_STATE, fmt.Sprintf("%v asset %v is a %v, not a car", function, v.V5cID, v.asset_type())).on_asset(v.V5cID)
+	}
+
+	v, err := t.apply_update(stub, v, caller, caller_affiliation, animals, function)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.save_changes(stub, v, caller, function)
+
+	if err != nil {
+		return nil, wrap_error("Error saving changes", err)
+	}
+
+	return nil, nil
+}
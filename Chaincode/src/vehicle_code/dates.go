@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Date formats - Dates are stored as ISO dates. SAP sends YYYYMMDD, which is accepted on input as well.
+//==============================================================================================================================
+const DATE_FORMAT = "2006-01-02"
+const SAP_DATE_FORMAT = "20060102"
+
+//==============================================================================================================================
+//	 DEFAULT_DATE_HORIZON_DAYS - How many days past the transaction a date may lie unless the deployment says otherwise.
+//==============================================================================================================================
+const DEFAULT_DATE_HORIZON_DAYS = 365
+
+//==============================================================================================================================
+//	 Date error rules - Which date check a field failed, see check_dates.
+//==============================================================================================================================
+const RULE_DATE = "date"
+const RULE_DATE_ORDER = "dateOrder"
+const RULE_DATE_HORIZON = "dateHorizon"
+
+//==============================================================================================================================
+//	 date_fields - The date fields of a Vehicle.
+//==============================================================================================================================
+var date_fields = []string{"truckPdate", "dmaDelDate", "afDelDate", "dmaMasdat"}
+
+//==============================================================================================================================
+//	 date_order - Date fields that must not decrease along the list: a truck is produced before it is delivered to the
+//				  DMA, and delivered to the DMA before it is delivered to the AF.
+//==============================================================================================================================
+var date_order = []string{"truckPdate", "dmaDelDate", "afDelDate"}
+
+//==============================================================================================================================
+//	 parse_date - Parses an ISO or SAP date.
+//==============================================================================================================================
+func parse_date(value string) (time.Time, bool) {
+
+	for _, layout := range []string{DATE_FORMAT, SAP_DATE_FORMAT} {
+		if d, err := time.Parse(layout, value); err == nil {
+			return d, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+//==============================================================================================================================
+//	 normal_date - Returns the ISO form of the date passed. Values that are no date are returned as they are.
+//==============================================================================================================================
+func normal_date(value string) string {
+
+	if d, ok := parse_date(value); ok {
+		return d.Format(DATE_FORMAT)
+	}
+
+	return value
+}
+
+//==============================================================================================================================
+//	 normalise_dates - Converts the date fields of an incoming asset to ISO dates.
+//==============================================================================================================================
+func (a *Animal) normalise_dates() {
+
+	for _, value := range []*string{&a.TruckPDate, &a.DmaDelDate, &a.AfDelDate, &a.DmaMasdat} {
+		*value = normal_date(*value)
+	}
+}
+
+//==============================================================================================================================
+//	 normalise_dates - Converts the date bounds of a filter to ISO dates. Returns an error for each bound that is no date.
+//==============================================================================================================================
+func (f *AssetFilter) normalise_dates() []FieldError {
+
+	errs := []FieldError{}
+
+	bounds := []struct {
+		Name  string
+		Value *string
+	}{
+		{"filter.dmaDelDateFrom", &f.DmaDelDateFrom},
+		{"filter.dmaDelDateTo", &f.DmaDelDateTo},
+		{"filter.afDelDateFrom", &f.AfDelDateFrom},
+		{"filter.afDelDateTo", &f.AfDelDateTo},
+	}
+
+	for _, bound := range bounds {
+
+		if *bound.Value == "" {
+			continue
+		}
+
+		if _, ok := parse_date(*bound.Value); !ok {
+			errs = append(errs, FieldError{Field: bound.Name, Rule: RULE_DATE, Message: bound.Name + " " + *bound.Value + " is not a YYYYMMDD or YYYY-MM-DD date"})
+			continue
+		}
+
+		*bound.Value = normal_date(*bound.Value)
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 check_dates - Checks the date fields of v, about to replace before, written at now. Changed dates must be dates no
+//				   more than horizon days past now, and the dates of date_order must keep their order. Dates stored
+//				   before these checks existed are left alone until they are changed.
+//==============================================================================================================================
+func check_dates(before Vehicle, v Vehicle, now time.Time, horizon int) []FieldError {
+
+	errs := []FieldError{}
+	changed := false
+	dates := map[string]time.Time{}
+
+	limit := now.AddDate(0, 0, horizon)
+
+	for _, name := range date_fields {
+
+		f, _ := find_field(name)
+
+		value := *f.Value(&v)
+
+		if value == "" {
+			continue
+		}
+
+		d, ok := parse_date(value)
+
+		if value == *f.Value(&before) {
+			if ok {
+				dates[name] = d
+			}
+			continue
+		}
+
+		changed = true
+
+		if !ok {
+			errs = append(errs, FieldError{Field: "asset." + name, Rule: RULE_DATE, Message: "asset." + name + " " + value + " is not a YYYYMMDD or YYYY-MM-DD date"})
+			continue
+		}
+
+		if d.After(limit) {
+			errs = append(errs, FieldError{Field: "asset." + name, Rule: RULE_DATE_HORIZON, Message: fmt.Sprintf("asset.%v %v lies more than %v days after the transaction", name, value, horizon)})
+		}
+
+		dates[name] = d
+	}
+
+	if !changed {
+		return errs
+	}
+
+	for i, earlier := range date_order {
+		for _, later := range date_order[i+1:] {
+
+			from, ok_from := dates[earlier]
+			to, ok_to := dates[later]
+
+			if ok_from && ok_to && to.Before(from) {
+				errs = append(errs, FieldError{Field: "asset." + later, Rule: RULE_DATE_ORDER, Message: "asset." + later + " " + to.Format(DATE_FORMAT) + " is before asset." + earlier + " " + from.Format(DATE_FORMAT)})
+			}
+		}
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 validate_dates - Checks the dates of v against the stored record before it is saved by function. Returns an
+//					  ERR_INVALID_INPUT error listing every date that failed.
+//==============================================================================================================================
+func (t *SimpleChaincode) validate_dates(stub shim.ChaincodeStubInterface, v Vehicle, function string) error {
+
+	var before Vehicle
+
+	bytes, err := stub.GetState(v.V5cID)
+
+	if err != nil {
+		return errors.New("Error retrieving vehicle record")
+	}
+
+	if bytes != nil {
+
+		err = json.Unmarshal(bytes, &before)
+
+		if err != nil {
+			return errors.New("Corrupt vehicle record " + string(bytes))
+		}
+	}
+
+	s, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return err
+	}
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return err
+	}
+
+	errs := check_dates(before, v, now, s.DateHorizonDays)
+
+	if len(errs) > 0 {
+		return invalid_input(function, "Invalid dates", errs)
+	}
+
+	return nil
+}
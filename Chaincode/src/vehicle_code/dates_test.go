@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalDate(t *testing.T) {
+
+	cases := []struct {
+		value string
+		want  string
+	}{
+		{"20170115", "2017-01-15"},
+		{"2017-01-15", "2017-01-15"},
+		{"20170230", "20170230"},
+		{"2017-1-15", "2017-1-15"},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if got := normal_date(c.value); got != c.want {
+			t.Errorf("normal_date(%q) = %q, want %q", c.value, got, c.want)
+		}
+	}
+}
+
+func TestCheckDates(t *testing.T) {
+
+	now := time.Date(2017, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	produced := Vehicle{TruckPDate: "2017-01-10"}
+
+	cases := []struct {
+		name   string
+		before Vehicle
+		v      Vehicle
+		want   []string
+	}{
+		{"ISO date", Vehicle{}, Vehicle{DmaDelDate: "2017-01-15"}, []string{}},
+		{"SAP date", Vehicle{}, Vehicle{DmaDelDate: "20170115"}, []string{}},
+		{"no date", Vehicle{}, Vehicle{DmaDelDate: "15.01.2017"}, []string{"asset.dmaDelDate " + RULE_DATE}},
+		{"impossible date", Vehicle{}, Vehicle{DmaMasdat: "2017-02-30"}, []string{"asset.dmaMasdat " + RULE_DATE}},
+		{"on the horizon", Vehicle{}, Vehicle{AfDelDate: "2018-01-02"}, []string{}},
+		{"past the horizon", Vehicle{}, Vehicle{AfDelDate: "2018-01-03"}, []string{"asset.afDelDate " + RULE_DATE_HORIZON}},
+		{"in order", produced, Vehicle{TruckPDate: "2017-01-10", DmaDelDate: "2017-01-10"}, []string{}},
+		{"before the previous date", produced, Vehicle{TruckPDate: "2017-01-10", DmaDelDate: "2017-01-09"}, []string{"asset.dmaDelDate " + RULE_DATE_ORDER}},
+		{"before a date further back", produced, Vehicle{TruckPDate: "2017-01-10", AfDelDate: "2017-01-05"}, []string{"asset.afDelDate " + RULE_DATE_ORDER}},
+		{"SAP and ISO dates are compared as dates", produced, Vehicle{TruckPDate: "2017-01-10", DmaDelDate: "20170109"}, []string{"asset.dmaDelDate " + RULE_DATE_ORDER}},
+		{"stored dates are left alone", Vehicle{DmaDelDate: "soon"}, Vehicle{DmaDelDate: "soon"}, []string{}},
+		{"stored order is left alone", Vehicle{TruckPDate: "2017-01-10", DmaDelDate: "2017-01-01"}, Vehicle{TruckPDate: "2017-01-10", DmaDelDate: "2017-01-01"}, []string{}},
+		{"unchanged dates are not checked against the horizon", Vehicle{AfDelDate: "2019-01-01"}, Vehicle{AfDelDate: "2019-01-01", DmaMasdat: "2017-01-03"}, []string{}},
+	}
+
+	for _, c := range cases {
+
+		got := []string{}
+
+		for _, e := range check_dates(c.before, c.v, now, 365) {
+			got = append(got, e.Field+" "+e.Rule)
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: check_dates = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Document keys - The metadata of document n of an asset is stored at DOC_PREFIX<assetID>~<docId>, its content at
+//					 DOC_CONTENT_PREFIX<assetID>~<docId>, so listing documents never loads their content.
+//					 DOC_SEQ_PREFIX<assetID> holds the number of the last document allocated.
+//==============================================================================================================================
+const DOC_PREFIX = "doc~"
+const DOC_CONTENT_PREFIX = "doccontent~"
+const DOC_SEQ_PREFIX = "docseq~"
+
+//==============================================================================================================================
+//	 MAX_DOC_SIZE - The largest base64 content accepted in a single transaction. maxDocSize of the configuration may
+//					lower it.
+//==============================================================================================================================
+const MAX_DOC_SIZE = 250000
+
+//==============================================================================================================================
+//	 Document storage - Inline documents keep their content on the ledger, off-chain documents only their hash and URI.
+//==============================================================================================================================
+const DOC_INLINE = "inline"
+const DOC_OFFCHAIN = "offchain"
+
+//==============================================================================================================================
+//	 Document types
+//==============================================================================================================================
+const DOC_DELIVERY_CERTIFICATE = "DELIVERY_CERTIFICATE"
+const DOC_TEST_REPORT = "TEST_REPORT"
+const DOC_INVOICE = "INVOICE"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	DocType - A kind of document and the roles that may upload it. The owner of an asset may upload any kind.
+//==============================================================================================================================
+type DocType struct {
+	Type  string
+	Roles []string
+}
+
+//==============================================================================================================================
+//	DocRequest - The "doc" object of the JSON argument of the document functions. Content is base64 encoded.
+//==============================================================================================================================
+type DocRequest struct {
+	DocId    string `json:"docId"`
+	DocType  string `json:"docType"`
+	FileName string `json:"fileName"`
+	MimeType string `json:"mimeType"`
+	Content  string `json:"content"`
+	UploadId string `json:"uploadId"` //chunked uploads only
+	Hash     string `json:"sha256"`   //checked at commitUpload, anchored by anchorDoc, looked up by verifyDoc
+	Offset   int    `json:"offset"`   //ranged reads only
+	Length   int    `json:"length"`   //ranged reads only
+	Size     int    `json:"size"`     //off-chain documents only
+	URI      string `json:"uri"`      //off-chain documents only
+}
+
+//==============================================================================================================================
+//	Document - The metadata of a document attached to an asset. Hash is the hex SHA-256 of the decoded content.
+//			   Off-chain documents are only anchored by their hash and may be replaced by later versions.
+//==============================================================================================================================
+type Document struct {
+	DocId        string `json:"docId"`
+	AssetId      string `json:"assetID"`
+	DocType      string `json:"docType"`
+	FileName     string `json:"fileName"`
+	MimeType     string `json:"mimeType"`
+	Size         int    `json:"size"`
+	Hash         string `json:"sha256"`
+	Uploader     string `json:"uploader"`
+	UploaderRole string `json:"uploaderRole"`
+	UploadedAt   string `json:"uploadedAt"`
+	TxID         string `json:"txnid"`
+	UploadId     string `json:"uploadId,omitempty"`   //set for documents uploaded in chunks
+	ChunkSizes   []int  `json:"chunkSizes,omitempty"` //size of each chunk of such documents
+	Storage      string `json:"storage"`
+	URI          string `json:"uri,omitempty"` //off-chain documents only
+	Version      int    `json:"version"`
+}
+
+//==============================================================================================================================
+//	 doc_types - The document types and who may upload them.
+//==============================================================================================================================
+var doc_types = []DocType{
+	{Type: DOC_DELIVERY_CERTIFICATE, Roles: []string{DMA, TRANSPORTER, AF}},
+	{Type: DOC_TEST_REPORT, Roles: []string{SUPPLIER, DMA, AF}},
+	{Type: DOC_INVOICE, Roles: []string{SUPPLIER, DMA, AF}},
+}
+
+//==============================================================================================================================
+//	 doc_key - Returns the key of the document passed under prefix.
+//==============================================================================================================================
+func doc_key(prefix string, v5cID string, docId string) string {
+
+	return prefix + v5cID + "~" + docId
+}
+
+//==============================================================================================================================
+//	 find_doc_type - Looks up the document type passed.
+//==============================================================================================================================
+func find_doc_type(doc_type string) (DocType, bool) {
+
+	for _, d := range doc_types {
+		if d.Type == doc_type {
+			return d, true
+		}
+	}
+
+	return DocType{}, false
+}
+
+//==============================================================================================================================
+//	 may_upload - Returns true if the caller, acting as role, may attach a document of doc_type to v.
+//==============================================================================================================================
+func may_upload(doc_type string, v Vehicle, caller string, role string) bool {
+
+	d, ok := find_doc_type(doc_type)
+
+	return ok && (v.OwnerId == caller || contains(d.Roles, role))
+}
+
+//==============================================================================================================================
+//	 may_read_doc - Returns true if the caller may read d: the asset's owner, the regulator and the uploader may.
+//==============================================================================================================================
+func may_read_doc(d Document, v Vehicle, caller string, role string) bool {
+
+	return v.OwnerId == caller || role == REGULATOR || d.Uploader == caller
+}
+
+//==============================================================================================================================
+//	 decode_content - Decodes base64 document content. A data URI prefix ("data:<mime>;base64,") is accepted and its
+//					  MIME type returned.
+//==============================================================================================================================
+func decode_content(content string) ([]byte, string, error) {
+
+	mime := ""
+
+	if strings.HasPrefix(content, "data:") {
+
+		i := strings.Index(content, ",")
+
+		if i < 0 {
+			return nil, "", new_error(ERR_INVALID_INPUT, "Invalid data URI, no ',' found")
+		}
+
+		mime = strings.TrimSuffix(strings.TrimPrefix(content[:i], "data:"), ";base64")
+		content = content[i+1:]
+	}
+
+	bytes, err := base64.StdEncoding.DecodeString(content)
+
+	if err != nil {
+		return nil, "", new_error(ERR_INVALID_INPUT, "Document content is not valid base64")
+	}
+
+	return bytes, mime, nil
+}
+
+//==============================================================================================================================
+//	 next_doc_id - Allocates the ID of the next document of the asset.
+//==============================================================================================================================
+func (t *SimpleChaincode) next_doc_id(stub shim.ChaincodeStubInterface, v5cID string) (string, error) {
+
+	n := 0
+
+	bytes, err := stub.GetState(DOC_SEQ_PREFIX + v5cID)
+
+	if err != nil {
+		return "", errors.New("Unable to get document sequence of " + v5cID)
+	}
+
+	if bytes != nil {
+
+		n, err = strconv.Atoi(string(bytes))
+
+		if err != nil {
+			return "", errors.New("Corrupt document sequence " + string(bytes))
+		}
+	}
+
+	n++
+
+	err = stub.PutState(DOC_SEQ_PREFIX+v5cID, []byte(strconv.Itoa(n)))
+
+	if err != nil {
+		return "", errors.New("Unable to store document sequence of " + v5cID)
+	}
+
+	return fmt.Sprintf("%06d", n), nil
+}
+
+//==============================================================================================================================
+//	 retrieve_doc - Gets the metadata of the document passed.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_doc(stub shim.ChaincodeStubInterface, v5cID string, docId string) (Document, error) {
+
+	var d Document
+
+	bytes, err := stub.GetState(doc_key(DOC_PREFIX, v5cID, docId))
+
+	if err != nil {
+		return d, errors.New("Unable to get document " + docId + " of " + v5cID)
+	}
+
+	if bytes == nil {
+		return d, new_error(ERR_NOT_FOUND, "Asset "+v5cID+" has no document "+docId).on_field("doc.docId")
+	}
+
+	err = json.Unmarshal(bytes, &d)
+
+	if err != nil {
+		return d, errors.New("Corrupt document record " + string(bytes))
+	}
+
+	return d, nil
+}
+
+//==============================================================================================================================
+//	 retrieve_docs - Gets the metadata of every document of the asset, oldest first.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_docs(stub shim.ChaincodeStubInterface, v5cID string) ([]Document, error) {
+
+	entries, err := range_entries(stub, DOC_PREFIX+v5cID+"~")
+
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []Document{}
+
+	for _, e := range entries {
+
+		var d Document
+
+		err = json.Unmarshal([]byte(e.Value), &d)
+
+		if err != nil {
+			return nil, errors.New("Corrupt document record " + e.Value)
+		}
+
+		docs = append(docs, d)
+	}
+
+	return docs, nil
+}
+
+//==============================================================================================================================
+//	 new_doc - Allocates a document ID and fills in the metadata of a new document of v uploaded by the caller.
+//==============================================================================================================================
+func (t *SimpleChaincode) new_doc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest, hash string, size int) (Document, error) {
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return Document{}, err
+	}
+
+	docId, err := t.next_doc_id(stub, v.V5cID)
+
+	if err != nil {
+		return Document{}, err
+	}
+
+	return Document{
+		DocId:        docId,
+		AssetId:      v.V5cID,
+		DocType:      doc.DocType,
+		FileName:     doc.FileName,
+		MimeType:     doc.MimeType,
+		Size:         size,
+		Hash:         hash,
+		Uploader:     caller,
+		UploaderRole: caller_affiliation,
+		UploadedAt:   now.Format(time.RFC3339),
+		TxID:         stub.GetTxID(),
+		Storage:      DOC_INLINE,
+		Version:      1,
+	}, nil
+}
+
+//==============================================================================================================================
+//	 record_doc - Writes the metadata of a document whose content is in place, records it in the asset's history and
+//				  announces it. Until then the document is not visible.
+//==============================================================================================================================
+func (t *SimpleChaincode) record_doc(stub shim.ChaincodeStubInterface, v Vehicle, d Document, caller string, function string) error {
+
+	bytes, err := json.Marshal(d)
+
+	if err != nil {
+		return errors.New("Error converting document record")
+	}
+
+	err = stub.PutState(doc_key(DOC_PREFIX, v.V5cID, d.DocId), bytes)
+
+	if err != nil {
+		return errors.New("Error storing document record")
+	}
+
+	changes := []FieldChange{{Field: "docs", New: fmt.Sprintf("%v %v sha256:%v (%v bytes)", d.DocId, d.DocType, d.Hash, d.Size)}}
+
+	err = t.append_history(stub, v.V5cID, changes, caller, function, "")
+
+	if err != nil {
+		return err
+	}
+
+	return t.emit_event(stub, event_type(function), v.V5cID, changes, v.OwnerId, v.OwnerId, caller)
+}
+
+//==============================================================================================================================
+//	 store_doc - Writes a new document of v with the content passed. Returns its metadata.
+//==============================================================================================================================
+func (t *SimpleChaincode) store_doc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, function string, doc DocRequest, content []byte) (Document, error) {
+
+	sum := sha256.Sum256(content)
+
+	d, err := t.new_doc(stub, v, caller, caller_affiliation, doc, hex.EncodeToString(sum[:]), len(content))
+
+	if err != nil {
+		return d, err
+	}
+
+	err = stub.PutState(doc_key(DOC_CONTENT_PREFIX, v.V5cID, d.DocId), content)
+
+	if err != nil {
+		return d, errors.New("Error storing document content")
+	}
+
+	return d, t.record_doc(stub, v, d, caller, function)
+}
+
+//==============================================================================================================================
+//	 read_doc_content - Returns length bytes of the content of d starting at offset. Documents uploaded in chunks are
+//						read from the chunks covering the range.
+//==============================================================================================================================
+func (t *SimpleChaincode) read_doc_content(stub shim.ChaincodeStubInterface, d Document, offset int, length int) ([]byte, error) {
+
+	if d.Storage == DOC_OFFCHAIN {
+		return nil, new_error(ERR_INVALID_STATE, "Document "+d.DocId+" is stored off-chain at "+d.URI).on_field("doc.docId")
+	}
+
+	if offset < 0 || length < 0 || offset+length > d.Size {
+		return nil, new_error(ERR_INVALID_INPUT, fmt.Sprintf("Invalid range %v+%v of document %v, size %v", offset, length, d.DocId, d.Size)).on_field("doc.offset")
+	}
+
+	if d.UploadId == "" {
+
+		content, err := stub.GetState(doc_key(DOC_CONTENT_PREFIX, d.AssetId, d.DocId))
+
+		if err != nil || len(content) != d.Size {
+			return nil, errors.New("Unable to get content of document " + d.DocId)
+		}
+
+		return content[offset : offset+length], nil
+	}
+
+	content := []byte{}
+	start := 0
+
+	for i, size := range d.ChunkSizes {
+
+		end := start + size
+
+		if end > offset && start < offset+length {
+
+			chunk, err := stub.GetState(chunk_key(d.AssetId, d.UploadId, i))
+
+			if err != nil || len(chunk) != size {
+				return nil, errors.New(fmt.Sprintf("Unable to get chunk %v of document %v", i, d.DocId))
+			}
+
+			from, to := 0, size
+
+			if offset > start {
+				from = offset - start
+			}
+
+			if offset+length < end {
+				to = offset + length - start
+			}
+
+			content = append(content, chunk[from:to]...)
+		}
+
+		start = end
+	}
+
+	return content, nil
+}
+
+//==============================================================================================================================
+//	 afdoc_view - Returns the content of the asset's latest document, base64 encoded, as the afDoc field used to hold
+//				  it. Assets without documents show whatever afDoc was stored on them; off-chain documents and
+//				  documents larger than the configured maxDocSize show as empty, the latter have to be read in ranges.
+//==============================================================================================================================
+func (t *SimpleChaincode) afdoc_view(stub shim.ChaincodeStubInterface, v Vehicle) (string, error) {
+
+	docs, err := t.retrieve_docs(stub, v.V5cID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(docs) == 0 {
+		return v.AfDoc, nil
+	}
+
+	latest := docs[len(docs)-1]
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return "", err
+	}
+
+	if latest.Size > settings.MaxDocSize || latest.Storage == DOC_OFFCHAIN {
+		return "", nil
+	}
+
+	content, err := t.read_doc_content(stub, latest, 0, latest.Size)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(content), nil
+}
+
+//=================================================================================================================================
+//	 addDoc - Attaches a document to the asset. Content is base64 encoded and limited to maxDocSize characters.
+//=================================================================================================================================
+func (t *SimpleChaincode) addDoc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	if _, ok := find_doc_type(doc.DocType); !ok {
+		return nil, new_error(ERR_INVALID_INPUT, fmt.Sprintf("Invalid docType %v, expected one of %v, %v, %v", doc.DocType, DOC_DELIVERY_CERTIFICATE, DOC_TEST_REPORT, DOC_INVOICE)).on_field("doc.docType")
+	}
+
+	if !may_upload(doc.DocType, v, caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. addDoc caller:%v role:%v may not upload documents of type %v", caller, caller_affiliation, doc.DocType))
+	}
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) > settings.MaxDocSize {
+		return nil, new_error(ERR_TOO_LARGE, fmt.Sprintf("Document content cannot be larger than %v characters", settings.MaxDocSize)).on_field("doc.content")
+	}
+
+	content, mime, err := decode_content(doc.Content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) == 0 {
+		return nil, new_error(ERR_INVALID_INPUT, "Document content cannot be empty").on_field("doc.content")
+	}
+
+	if doc.MimeType == "" {
+		doc.MimeType = mime
+	}
+
+	d, err := t.store_doc(stub, v, caller, caller_affiliation, "addDoc", doc, content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(d)
+}
+
+//=================================================================================================================================
+//	 listDocs - Returns the metadata of the asset's documents the caller may read, oldest first.
+//=================================================================================================================================
+func (t *SimpleChaincode) listDocs(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+
+	docs, err := t.retrieve_docs(stub, v.V5cID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	readable := []Document{}
+
+	for _, d := range docs {
+		if may_read_doc(d, v, caller, caller_affiliation) {
+			readable = append(readable, d)
+		}
+	}
+
+	bytes, err := json.Marshal(struct {
+		AssetId string     `json:"assetID"`
+		Docs    []Document `json:"docs"`
+	}{v.V5cID, readable})
+
+	if err != nil {
+		return nil, errors.New("LISTDOCS: Error converting documents")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 readDocById - Returns the metadata and base64 encoded content of one document of the asset. A length limits the
+//				   content to that many bytes from offset, so large documents are fetched over several queries. A
+//				   range running past the end is cut short. Off-chain documents come without content.
+//=================================================================================================================================
+func (t *SimpleChaincode) readDocById(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, docId string, offset int, length int) ([]byte, error) {
+
+	d, err := t.retrieve_doc(stub, v.V5cID, docId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !may_read_doc(d, v, caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readDoc. The caller should be owner, uploader or Regulator.")
+	}
+
+	var content []byte
+
+	if d.Storage != DOC_OFFCHAIN {
+
+		if length == 0 || offset+length > d.Size {
+			length = d.Size - offset
+		}
+
+		settings, err := t.retrieve_settings(stub)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if length > settings.MaxDocSize {
+			return nil, new_error(ERR_TOO_LARGE, fmt.Sprintf("Document %v is too large to read at once, read it in ranges of at most %v bytes", docId, settings.MaxDocSize)).on_field("doc.length")
+		}
+
+		content, err = t.read_doc_content(stub, d, offset, length)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bytes, err := json.Marshal(struct {
+		Document
+		Offset  int    `json:"offset"`
+		Content []byte `json:"content"`
+	}{d, offset, content})
+
+	if err != nil {
+		return nil, errors.New("READDOC: Error converting document")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Response formats - FORMAT_LEGACY wraps assets in the envelope the UI reads, FORMAT_RAW returns them as stored.
+//==============================================================================================================================
+const FORMAT_LEGACY = "legacy"
+const FORMAT_RAW = "raw"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	ResponseOptions - The "options" object of the JSON argument of queries returning assets.
+//==============================================================================================================================
+type ResponseOptions struct {
+	Format string `json:"format"`
+}
+
+//==============================================================================================================================
+//	AssetState - The "assetstate" object of the envelope. Asset is the Vehicle, or the afDoc view for readDoc.
+//==============================================================================================================================
+type AssetState struct {
+	Asset interface{} `json:"asset"`
+}
+
+//==============================================================================================================================
+//	Envelope - The legacy response for one asset: the asset, the transaction answering the query and the version of
+//			   the asset with who last changed it when, taken from its history.
+//==============================================================================================================================
+type Envelope struct {
+	AssetState     AssetState `json:"assetstate"`
+	TxID           string     `json:"txnid"`
+	Timestamp      string     `json:"txnts"`
+	Version        int        `json:"version"`
+	LastModified   string     `json:"lastModified"`
+	LastModifiedBy string     `json:"lastModifiedBy"`
+}
+
+//==============================================================================================================================
+//	PageEnvelope - The response for a page of assets, each rendered in the format requested. Bookmark is empty on the
+//				   last page. Errors holds the errors of records of the page that could not be read.
+//==============================================================================================================================
+type PageEnvelope struct {
+	Assets    []json.RawMessage `json:"assets"`
+	TxID      string            `json:"txnid,omitempty"`
+	Timestamp string            `json:"txnts,omitempty"`
+	Bookmark  string            `json:"bookmark"`
+	Errors    []ChaincodeError  `json:"errors,omitempty"`
+}
+
+//==============================================================================================================================
+//	 response_format - Returns the format requested, FORMAT_LEGACY if none is.
+//==============================================================================================================================
+func (o ResponseOptions) response_format() string {
+
+	if o.Format == "" {
+		return FORMAT_LEGACY
+	}
+
+	return o.Format
+}
+
+//==============================================================================================================================
+//	 tx_stamp - Returns the ID and, where the peer supplies one, the timestamp of the current transaction.
+//==============================================================================================================================
+func (t *SimpleChaincode) tx_stamp(stub shim.ChaincodeStubInterface) (string, string) {
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return stub.GetTxID(), ""
+	}
+
+	return stub.GetTxID(), now.Format(time.RFC3339)
+}
+
+//==============================================================================================================================
+//	 new_envelope - Wraps the asset passed, belonging to v5cID, in the legacy envelope.
+//==============================================================================================================================
+func (t *SimpleChaincode) new_envelope(stub shim.ChaincodeStubInterface, v5cID string, asset interface{}) (Envelope, error) {
+
+	head, err := t.retrieve_history_head(stub, v5cID)
+
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	e := Envelope{
+		AssetState:     AssetState{Asset: asset},
+		Version:        head.Version,
+		LastModified:   head.Timestamp,
+		LastModifiedBy: head.Caller,
+	}
+
+	e.TxID, e.Timestamp = t.tx_stamp(stub)
+
+	return e, nil
+}
+
+//==============================================================================================================================
+//	 render_asset - Returns the asset passed in the format requested.
+//==============================================================================================================================
+func (t *SimpleChaincode) render_asset(stub shim.ChaincodeStubInterface, v5cID string, asset interface{}, format string) ([]byte, error) {
+
+	var response interface{} = asset
+
+	if format != FORMAT_RAW {
+
+		e, err := t.new_envelope(stub, v5cID, asset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		response = e
+	}
+
+	bytes, err := json.Marshal(response)
+
+	if err != nil {
+		return nil, errors.New("READASSET: Invalid vehicle object")
+	}
+
+	return bytes, nil
+}
+
+//==============================================================================================================================
+//	 render_page - Returns a page of assets already rendered in the format requested. The page is a PageEnvelope in
+//				   the legacy format as well; clients reading it as an array of asset envelopes read page.assets.
+//==============================================================================================================================
+func (t *SimpleChaincode) render_page(stub shim.ChaincodeStubInterface, assets []json.RawMessage, errs []ChaincodeError, bookmark string, format string) ([]byte, error) {
+
+	page := PageEnvelope{Assets: assets, Bookmark: bookmark, Errors: errs}
+
+	if format != FORMAT_RAW {
+		page.TxID, page.Timestamp = t.tx_stamp(stub)
+	}
+
+	bytes, err := json.Marshal(page)
+
+	if err != nil {
+		return nil, errors.New("Error converting page of assets")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Error codes - The stable codes every chaincode function reports its errors with. Clients branch on the code,
+//				   the message is for people and may change.
+//==============================================================================================================================
+const ERR_INVALID_JSON = "INVALID_JSON"
+const ERR_INVALID_INPUT = "INVALID_INPUT"
+const ERR_UNKNOWN_FUNCTION = "UNKNOWN_FUNCTION"
+const ERR_UNAUTHENTICATED = "UNAUTHENTICATED"
+const ERR_PERMISSION_DENIED = "PERMISSION_DENIED"
+const ERR_NOT_FOUND = "NOT_FOUND"
+const ERR_ALREADY_EXISTS = "ALREADY_EXISTS"
+const ERR_DUPLICATE_KEY = "DUPLICATE_KEY"
+const ERR_INVALID_STATE = "INVALID_STATE"
+const ERR_HASH_MISMATCH = "HASH_MISMATCH"
+const ERR_TOO_LARGE = "TOO_LARGE"
+const ERR_FEATURE_DISABLED = "FEATURE_DISABLED"
+const ERR_INTERNAL = "INTERNAL"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	ErrorCode - One entry of the error catalogue. HttpStatus is the status a REST gateway should answer with.
+//==============================================================================================================================
+type ErrorCode struct {
+	Code        string `json:"code"`
+	HttpStatus  int    `json:"httpStatus"`
+	Description string `json:"description"`
+}
+
+//==============================================================================================================================
+//	ChaincodeError - The error every chaincode function returns. Its text is the JSON form, so clients read the code,
+//					 and the field or asset the error is about, from the error message of the transaction. Errors
+//					 lists each field that failed when a payload is rejected.
+//==============================================================================================================================
+type ChaincodeError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Field   string       `json:"field,omitempty"`
+	AssetId string       `json:"assetID,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+func (e ChaincodeError) Error() string {
+
+	bytes, err := json.Marshal(e)
+
+	if err != nil {
+		return e.Code + ": " + e.Message
+	}
+
+	return string(bytes)
+}
+
+//==============================================================================================================================
+//	 error_codes - The error catalogue, returned by listErrorCodes.
+//==============================================================================================================================
+var error_codes = []ErrorCode{
+	{ERR_INVALID_JSON, http.StatusBadRequest, "The argument is not a single JSON object"},
+	{ERR_INVALID_INPUT, http.StatusBadRequest, "A field is missing, unknown or holds a value it may not hold; see errors for each field"},
+	{ERR_UNKNOWN_FUNCTION, http.StatusNotFound, "There is no chaincode function of that name and type"},
+	{ERR_UNAUTHENTICATED, http.StatusUnauthorized, "The caller could not be established from the certificate"},
+	{ERR_PERMISSION_DENIED, http.StatusForbidden, "The caller may not call the function or change the fields passed"},
+	{ERR_NOT_FOUND, http.StatusNotFound, "The asset, document, upload or transfer does not exist"},
+	{ERR_ALREADY_EXISTS, http.StatusConflict, "An asset with that ID exists already"},
+	{ERR_DUPLICATE_KEY, http.StatusConflict, "A unique business key is carried by another asset"},
+	{ERR_INVALID_STATE, http.StatusConflict, "The asset, document or transfer is not in a state that allows the call"},
+	{ERR_HASH_MISMATCH, http.StatusUnprocessableEntity, "The content does not have the SHA-256 it was declared with"},
+	{ERR_TOO_LARGE, http.StatusRequestEntityTooLarge, "The content is larger than allowed"},
+	{ERR_FEATURE_DISABLED, http.StatusForbidden, "The function belongs to a feature the regulator switched off"},
+	{ERR_INTERNAL, http.StatusInternalServerError, "The ledger could not be read or written, or holds a corrupt record"},
+}
+
+//==============================================================================================================================
+//	 new_error - Returns an error with the code and message passed.
+//==============================================================================================================================
+func new_error(code string, message string) ChaincodeError {
+
+	return ChaincodeError{Code: code, Message: message}
+}
+
+//==============================================================================================================================
+//	 invalid_input - Returns the error for a payload whose fields failed the checks listed in errs.
+//==============================================================================================================================
+func invalid_input(function string, message string, errs []FieldError) ChaincodeError {
+
+	e := ChaincodeError{Code: ERR_INVALID_INPUT, Message: function + ": " + message, Errors: errs}
+
+	if len(errs) > 0 {
+		e.Field = errs[0].Field
+	}
+
+	return e
+}
+
+//==============================================================================================================================
+//	 on_field - Returns the error marked as being about the payload field at path.
+//==============================================================================================================================
+func (e ChaincodeError) on_field(path string) ChaincodeError {
+
+	e.Field = path
+
+	return e
+}
+
+//==============================================================================================================================
+//	 on_asset - Returns the error marked as being about the asset passed.
+//==============================================================================================================================
+func (e ChaincodeError) on_asset(v5cID string) ChaincodeError {
+
+	e.AssetId = v5cID
+
+	return e
+}
+
+//==============================================================================================================================
+//	 wrap_error - Puts context in front of the message of err, keeping its code.
+//==============================================================================================================================
+func wrap_error(context string, err error) error {
+
+	if e, ok := err.(ChaincodeError); ok {
+		e.Message = context + ": " + e.Message
+		return e
+	}
+
+	return errors.New(context + ": " + err.Error())
+}
+
+//==============================================================================================================================
+//	 as_chaincode_error - Turns any error into a ChaincodeError. Errors without a code are failures to read or write
+//						  the ledger and become ERR_INTERNAL. The asset passed is filled in if the error names none.
+//==============================================================================================================================
+func as_chaincode_error(err error, v5cID string) ChaincodeError {
+
+	e, ok := err.(ChaincodeError)
+
+	if !ok {
+		e = new_error(ERR_INTERNAL, err.Error())
+	}
+
+	if e.AssetId == "" {
+		e.AssetId = v5cID
+	}
+
+	return e
+}
+
+//=================================================================================================================================
+//	 listErrorCodes - Returns the error catalogue so clients can map codes onto HTTP statuses.
+//=================================================================================================================================
+func (t *SimpleChaincode) listErrorCodes(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	bytes, err := json.Marshal(error_codes)
+
+	if err != nil {
+		return nil, errors.New("LISTERRORCODES: Error converting error codes")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Event constants - Every asset event is sent under EVENT_NAME. EVENT_VERSION is raised whenever the payload format
+//					   changes incompatibly so consumers can tell the formats apart.
+//==============================================================================================================================
+const EVENT_NAME = "assetEvent"
+const EVENT_VERSION = 1
+
+//==============================================================================================================================
+//	 Event types
+//==============================================================================================================================
+const EVENT_ASSET_CREATED = "AssetCreated"
+const EVENT_ASSETS_CREATED = "AssetsCreated"
+const EVENT_ASSET_UPDATED = "AssetUpdated"
+const EVENT_ASSETS_UPDATED = "AssetsUpdated"
+const EVENT_DOC_UPDATED = "DocumentUpdated"
+const EVENT_DOC_ADDED = "DocumentAdded"
+const EVENT_STATUS_CHANGED = "StatusChanged"
+const EVENT_TRANSFER_OFFERED = "TransferOffered"
+const EVENT_TRANSFER_ACCEPTED = "TransferAccepted"
+const EVENT_TRANSFER_REJECTED = "TransferRejected"
+const EVENT_TRANSFER_CANCELLED = "TransferCancelled"
+const EVENT_OWNER_CHANGED = "OwnerChanged"
+
+//==============================================================================================================================
+//	 event_types - The event type sent for a record written by the chaincode function named.
+//==============================================================================================================================
+var event_types = map[string]string{
+	"create_vehicle":  EVENT_ASSET_CREATED,
+	"createAsset":     EVENT_ASSET_CREATED,
+	"updateAsset":     EVENT_ASSET_UPDATED,
+	"updateDoc":       EVENT_DOC_UPDATED,
+	"addDoc":          EVENT_DOC_ADDED,
+	"commitUpload":    EVENT_DOC_ADDED,
+	"anchorDoc":       EVENT_DOC_ADDED,
+	"transitionAsset": EVENT_STATUS_CHANGED,
+	"acceptTransfer":  EVENT_TRANSFER_ACCEPTED,
+
+	"authority_to_manufacturer": EVENT_OWNER_CHANGED,
+	"manufacturer_to_private":   EVENT_OWNER_CHANGED,
+	"private_to_private":        EVENT_OWNER_CHANGED,
+	"private_to_lease_company":  EVENT_OWNER_CHANGED,
+	"lease_company_to_private":  EVENT_OWNER_CHANGED,
+	"private_to_scrap_merchant": EVENT_OWNER_CHANGED,
+	"scrap_vehicle":             EVENT_STATUS_CHANGED,
+}
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	AssetEvent - Payload of an asset event. Only names of changed fields are sent, never their values, so document
+//				 bodies stay off the event stream. Events of a batch name every asset of the batch in AssetIds.
+//==============================================================================================================================
+type AssetEvent struct {
+	Version   int      `json:"version"`
+	Type      string   `json:"type"`
+	AssetId   string   `json:"assetID"`
+	AssetIds  []string `json:"assetIDs,omitempty"`
+	Changed   []string `json:"changedFields"`
+	OldOwner  string   `json:"oldOwner"`
+	NewOwner  string   `json:"newOwner"`
+	Caller    string   `json:"caller"`
+	TxID      string   `json:"txnid"`
+	Timestamp string   `json:"txnts"`
+}
+
+//==============================================================================================================================
+//	 event_type - Returns the event type for the chaincode function named.
+//==============================================================================================================================
+func event_type(function string) string {
+
+	if name, ok := event_types[function]; ok {
+		return name
+	}
+
+	return EVENT_ASSET_UPDATED
+}
+
+//==============================================================================================================================
+//	 emit_event - Sets the chaincode event of the transaction. Fabric keeps one event per transaction, so this is
+//				  called once per mutation.
+//==============================================================================================================================
+func (t *SimpleChaincode) emit_event(stub shim.ChaincodeStubInterface, kind string, v5cID string, changes []FieldChange, old_owner string, new_owner string, caller string) error {
+
+	changed := []string{}
+
+	for _, c := range changes {
+		changed = append(changed, c.Field)
+	}
+
+	return t.set_event(stub, AssetEvent{
+		Type:     kind,
+		AssetId:  v5cID,
+		Changed:  changed,
+		OldOwner: old_owner,
+		NewOwner: new_owner,
+		Caller:   caller,
+	})
+}
+
+//==============================================================================================================================
+//	 emit_batch_event - Sets the one chaincode event of a transaction that wrote the assets passed.
+//==============================================================================================================================
+func (t *SimpleChaincode) emit_batch_event(stub shim.ChaincodeStubInterface, kind string, v5cIDs []string, caller string) error {
+
+	return t.set_event(stub, AssetEvent{Type: kind, AssetIds: v5cIDs, Changed: []string{}, Caller: caller})
+}
+
+//==============================================================================================================================
+//	 set_event - Stamps the event passed with the version, transaction and time and sets it.
+//==============================================================================================================================
+func (t *SimpleChaincode) set_event(stub shim.ChaincodeStubInterface, e AssetEvent) error {
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return err
+	}
+
+	e.Version = EVENT_VERSION
+	e.TxID = stub.GetTxID()
+	e.Timestamp = now.Format(time.RFC3339)
+
+	bytes, err := json.Marshal(e)
+
+	if err != nil {
+		return errors.New("Error converting asset event")
+	}
+
+	err = stub.SetEvent(EVENT_NAME, bytes)
+
+	if err != nil {
+		return errors.New("Error setting asset event")
+	}
+
+	return nil
+}
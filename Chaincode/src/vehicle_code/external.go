@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Update outcomes - What applyExternalUpdates did with one record.
+//==============================================================================================================================
+const UPDATE_APPLIED = "applied"
+const UPDATE_NO_MATCH = "noMatch"
+const UPDATE_AMBIGUOUS = "ambiguous"
+const UPDATE_REJECTED = "rejected"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	ExternalUpdate - One object of the "updates" array of applyExternalUpdates: a change message of an external system,
+//					 e.g. SAP, for the assets whose KeyField, one of poDma, poSupp or matnrAf, holds KeyValue. A key
+//					 matching more than one asset is only applied if ApplyToAll is set.
+//==============================================================================================================================
+type ExternalUpdate struct {
+	MessageId  string `json:"messageId"`
+	KeyField   string `json:"keyField"`
+	KeyValue   string `json:"keyValue"`
+	ApplyToAll bool   `json:"applyToAll"`
+	Asset      Animal `json:"asset"`
+}
+
+//==============================================================================================================================
+//	UpdateReport - The outcome of one record of applyExternalUpdates. Index is its position in the "updates" array,
+//				   AssetIds the assets its key matched and Error why a rejected record was not applied.
+//==============================================================================================================================
+type UpdateReport struct {
+	Index     int             `json:"index"`
+	MessageId string          `json:"messageId"`
+	Status    string          `json:"status"`
+	AssetIds  []string        `json:"assetIDs"`
+	Error     *ChaincodeError `json:"error,omitempty"`
+}
+
+//==============================================================================================================================
+//	ExternalUpdatesReport - The response of applyExternalUpdates.
+//==============================================================================================================================
+type ExternalUpdatesReport struct {
+	Applied int            `json:"applied"`
+	Records []UpdateReport `json:"records"`
+}
+
+//==============================================================================================================================
+//	 prepare_external_update - Returns the assets passed with the changes of u applied, once every one of them passes
+//							   the checks of updateAsset for the caller. Nothing is written. A failed check names
+//							   the asset it failed on.
+//==============================================================================================================================
+func (t *SimpleChaincode) prepare_external_update(stub shim.ChaincodeStubInterface, v5cIDs []string, caller string, caller_affiliation string, u ExternalUpdate) ([]Vehicle, error) {
+
+	updated := []Vehicle{}
+
+	for _, v5cID := range v5cIDs {
+
+		v, err := t.retrieve_v5c(stub, v5cID)
+
+		if err != nil {
+			return nil, wrap_error("Error retrieving v5c", err)
+		}
+
+		after, err := t.apply_update(stub, v, caller, caller_affiliation, u.Asset, "applyExternalUpdates")
+
+		if err == nil {
+			err = t.check_lookups(stub, v, after)
+		}
+
+		if e, ok := err.(ChaincodeError); ok {
+			return nil, e.on_asset(v5cID)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		updated = append(updated, after)
+	}
+
+	return updated, nil
+}
+
+//=================================================================================================================================
+//	 applyExternalUpdates - Applies change messages keyed by a business key rather than an asset ID. Each record is
+//							resolved to its assets through the lookup indexes and applied to all of them or none,
+//							with the role rules of updateAsset. A record that matches no asset, matches several
+//							without applyToAll, or fails a check is reported and skipped; the others are applied and
+//							their message ID recorded in the history of each asset changed. The assets changed are
+//							announced in one event.
+//=================================================================================================================================
+func (t *SimpleChaincode) applyExternalUpdates(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, updates []ExternalUpdate) ([]byte, error) {
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(updates) > settings.MaxBatchSize {
+		return nil, new_error(ERR_TOO_LARGE, fmt.Sprintf("applyExternalUpdates takes at most %v updates at once", settings.MaxBatchSize)).on_field("updates")
+	}
+
+	report := ExternalUpdatesReport{Records: []UpdateReport{}}
+	changed := []string{}
+
+	for i, u := range updates {
+
+		record := UpdateReport{Index: i, MessageId: u.MessageId, AssetIds: []string{}}
+
+		u.Asset.normalise_dates()
+
+		v5cIDs, err := t.lookup_asset_ids(stub, u.KeyField, u.KeyValue)
+
+		if err != nil {
+			return nil, err
+		}
+
+		record.AssetIds = append(record.AssetIds, v5cIDs...)
+
+		switch {
+
+		case len(v5cIDs) == 0:
+			record.Status = UPDATE_NO_MATCH
+
+		case len(v5cIDs) > 1 && !u.ApplyToAll:
+			record.Status = UPDATE_AMBIGUOUS
+
+		default:
+
+			assets, err := t.prepare_external_update(stub, v5cIDs, caller, caller_affiliation, u)
+
+			if err != nil {
+
+				e, ok := err.(ChaincodeError)
+
+				if !ok {
+					return nil, err
+				}
+
+				record.Status = UPDATE_REJECTED
+				record.Error = &e
+				break
+			}
+
+			for _, v := range assets {
+
+				_, _, err := t.store_changes(stub, v, caller, "applyExternalUpdates", u.MessageId)
+
+				if err != nil {
+					return nil, wrap_error(fmt.Sprintf("Error saving asset %v of message %v", v.V5cID, u.MessageId), err)
+				}
+
+				if !contains(changed, v.V5cID) {
+					changed = append(changed, v.V5cID)
+				}
+			}
+
+			record.Status = UPDATE_APPLIED
+			report.Applied++
+		}
+
+		report.Records = append(report.Records, record)
+	}
+
+	if len(changed) > 0 {
+
+		err := t.emit_batch_event(stub, EVENT_ASSETS_UPDATED, changed, caller)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bytes, err := json.Marshal(report)
+
+	if err != nil {
+		return nil, errors.New("APPLYEXTERNALUPDATES: Error converting report")
+	}
+
+	return bytes, nil
+}
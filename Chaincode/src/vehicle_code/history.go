@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 History keys - Entry n of an asset's history is stored at HISTORY_PREFIX<assetID>~<n, zero padded>. The head record
+//					at HISTORY_HEAD_PREFIX<assetID> holds the latest version and who wrote it.
+//==============================================================================================================================
+const HISTORY_PREFIX = "history~"
+const HISTORY_HEAD_PREFIX = "historyhead~"
+
+//==============================================================================================================================
+//	 History paging - Page size used when none is requested and the largest one allowed. The configuration's
+//					  defaultPageSize and maxPageSize start from these and may not exceed MAX_PAGE_SIZE.
+//==============================================================================================================================
+const DEFAULT_PAGE_SIZE = 20
+const MAX_PAGE_SIZE = 100
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	FieldChange - The value of one field before and after a change.
+//==============================================================================================================================
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+//==============================================================================================================================
+//	HistoryEntry - One version of an asset: which transaction wrote it, when, who called which function and what changed.
+//				   MessageId is the ID of the external message the change was made for, if any.
+//==============================================================================================================================
+type HistoryEntry struct {
+	AssetId   string        `json:"assetID"`
+	Version   int           `json:"version"`
+	TxID      string        `json:"txnid"`
+	Timestamp string        `json:"txnts"`
+	Caller    string        `json:"caller"`
+	Function  string        `json:"function"`
+	MessageId string        `json:"messageId,omitempty"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+//==============================================================================================================================
+//	HistoryHead - The latest version of an asset. Kept apart from the entries so appending needs no range query.
+//==============================================================================================================================
+type HistoryHead struct {
+	AssetId   string `json:"assetID"`
+	Version   int    `json:"version"`
+	TxID      string `json:"txnid"`
+	Timestamp string `json:"txnts"`
+	Caller    string `json:"caller"`
+	Function  string `json:"function"`
+}
+
+//==============================================================================================================================
+//	PageRequest - The "page" object of the JSON argument of paged queries. Bookmark is returned by the previous page.
+//				  SortBy is only read by readAllAssets.
+//==============================================================================================================================
+type PageRequest struct {
+	Size     int    `json:"size"`
+	Bookmark string `json:"bookmark"`
+	SortBy   string `json:"sortBy"`
+}
+
+//==============================================================================================================================
+//	 page_size - Returns the page size requested, bounded by MAX_PAGE_SIZE.
+//==============================================================================================================================
+func (p PageRequest) page_size() int {
+
+	if p.Size <= 0 {
+		return DEFAULT_PAGE_SIZE
+	}
+
+	if p.Size > MAX_PAGE_SIZE {
+		return MAX_PAGE_SIZE
+	}
+
+	return p.Size
+}
+
+//==============================================================================================================================
+//	 history_key - Returns the world state key of version n of the asset's history.
+//==============================================================================================================================
+func history_key(v5cID string, n int) string {
+
+	return fmt.Sprintf("%v%v~%010d", HISTORY_PREFIX, v5cID, n)
+}
+
+//==============================================================================================================================
+//	 history_value - The value of a field as recorded in the history. Document bodies are recorded by size and hash
+//					 only so the history does not grow by the size of every upload.
+//==============================================================================================================================
+func history_value(field string, value string) string {
+
+	if field != "afDoc" || value == "" {
+		return value
+	}
+
+	sum := sha256.Sum256([]byte(value))
+
+	return fmt.Sprintf("sha256:%v (%v bytes)", hex.EncodeToString(sum[:]), len(value))
+}
+
+//==============================================================================================================================
+//	 field_changes - Returns the fields that differ between before and after, including the status.
+//==============================================================================================================================
+func field_changes(before Vehicle, after Vehicle) []FieldChange {
+
+	changes := []FieldChange{}
+
+	for _, f := range asset_fields {
+
+		old_value, new_value := *f.Value(&before), *f.Value(&after)
+
+		if old_value != new_value {
+			changes = append(changes, FieldChange{Field: f.Name, Old: history_value(f.Name, old_value), New: history_value(f.Name, new_value)})
+		}
+	}
+
+	attributes := map[string]string{}
+
+	for name, value := range before.Attributes {
+		attributes[name] = value
+	}
+
+	for name, value := range after.Attributes {
+		attributes[name] = value
+	}
+
+	for _, name := range sorted_keys(attributes) {
+
+		old_value, new_value := before.Attributes[name], after.Attributes[name]
+
+		if old_value != new_value {
+			changes = append(changes, FieldChange{Field: ATTRIBUTE_PREFIX + name, Old: old_value, New: new_value})
+		}
+	}
+
+	if before.Status != after.Status {
+		changes = append(changes, FieldChange{Field: "status", Old: before.Status, New: after.Status})
+	}
+
+	return changes
+}
+
+//==============================================================================================================================
+//	 retrieve_history_head - Gets the history head of the asset. An asset without history is at version 0.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_history_head(stub shim.ChaincodeStubInterface, v5cID string) (HistoryHead, error) {
+
+	head := HistoryHead{AssetId: v5cID}
+
+	bytes, err := stub.GetState(HISTORY_HEAD_PREFIX + v5cID)
+
+	if err != nil {
+		return head, errors.New("Unable to get history of " + v5cID)
+	}
+
+	if bytes == nil {
+		return head, nil
+	}
+
+	err = json.Unmarshal(bytes, &head)
+
+	if err != nil {
+		return head, errors.New("Corrupt history head record " + string(bytes))
+	}
+
+	return head, nil
+}
+
+//==============================================================================================================================
+//	 append_history - Records the changes passed as the next version of the asset. message_id is empty unless the
+//					  changes were made for an external message.
+//==============================================================================================================================
+func (t *SimpleChaincode) append_history(stub shim.ChaincodeStubInterface, v5cID string, changes []FieldChange, caller string, function string, message_id string) error {
+
+	head, err := t.retrieve_history_head(stub, v5cID)
+
+	if err != nil {
+		return err
+	}
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return err
+	}
+
+	entry := HistoryEntry{
+		AssetId:   v5cID,
+		Version:   head.Version + 1,
+		TxID:      stub.GetTxID(),
+		Timestamp: now.Format(time.RFC3339),
+		Caller:    caller,
+		Function:  function,
+		MessageId: message_id,
+		Changes:   changes,
+	}
+
+	bytes, err := json.Marshal(entry)
+
+	if err != nil {
+		return errors.New("Error converting history record")
+	}
+
+	err = stub.PutState(history_key(entry.AssetId, entry.Version), bytes)
+
+	if err != nil {
+		return errors.New("Error storing history record")
+	}
+
+	head = HistoryHead{AssetId: entry.AssetId, Version: entry.Version, TxID: entry.TxID, Timestamp: entry.Timestamp, Caller: caller, Function: function}
+
+	bytes, err = json.Marshal(head)
+
+	if err != nil {
+		return errors.New("Error converting history head record")
+	}
+
+	err = stub.PutState(HISTORY_HEAD_PREFIX+entry.AssetId, bytes)
+
+	if err != nil {
+		return errors.New("Error storing history head record")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 readAssetHistory - Returns a page of the asset's history, oldest version first. The bookmark of the response is
+//						passed back to get the next page and is empty on the last one.
+//=================================================================================================================================
+func (t *SimpleChaincode) readAssetHistory(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, page PageRequest) ([]byte, error) {
+
+	if v.OwnerId != caller && caller_affiliation != REGULATOR {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readAssetHistory. The caller should be owner or Regulator.")
+	}
+
+	head, err := t.retrieve_history_head(stub, v.V5cID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	from := 1
+
+	if page.Bookmark != "" {
+
+		from, err = strconv.Atoi(page.Bookmark)
+
+		if err != nil || from < 1 {
+			return nil, new_error(ERR_INVALID_INPUT, "Invalid bookmark "+page.Bookmark).on_field("page.bookmark")
+		}
+	}
+
+	entries := []HistoryEntry{}
+
+	n := from
+
+	for ; n <= head.Version && len(entries) < page.page_size(); n++ {
+
+		bytes, err := stub.GetState(history_key(v.V5cID, n))
+
+		if err != nil {
+			return nil, errors.New("Unable to get history record " + strconv.Itoa(n) + " of " + v.V5cID)
+		}
+
+		var entry HistoryEntry
+
+		err = json.Unmarshal(bytes, &entry)
+
+		if err != nil {
+			return nil, errors.New("Corrupt history record " + string(bytes))
+		}
+
+		entries = append(entries, entry)
+	}
+
+	bookmark := ""
+
+	if n <= head.Version {
+		bookmark = strconv.Itoa(n)
+	}
+
+	bytes, err := json.Marshal(struct {
+		AssetId  string         `json:"assetID"`
+		Version  int            `json:"version"`
+		History  []HistoryEntry `json:"history"`
+		Bookmark string         `json:"bookmark"`
+	}{v.V5cID, head.Version, entries, bookmark})
+
+	if err != nil {
+		return nil, errors.New("READASSETHISTORY: Error converting history")
+	}
+
+	return bytes, nil
+}
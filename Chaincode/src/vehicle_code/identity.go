@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 establish_caller - Fills in the caller and participant role of the request.
+//						In strict mode both come from the eCert attributes 'username' and 'role'; a caller passed in the
+//						payload must name the same user. In legacy mode the payload caller is trusted and, as the UI
+//						passes participant codes as callers, doubles as the role when it is one. Roles are mapped as
+//						configured, see Settings.Roles.
+//==============================================================================================================================
+func (t *SimpleChaincode) establish_caller(stub shim.ChaincodeStubInterface, s Settings, req *Request) error {
+
+	if s.IdentityMode == IDENTITY_LEGACY {
+
+		req.Caller = req.Asset.Caller
+
+		if role, ok := s.participant_role(req.Caller); ok {
+			req.Affiliation = role
+			return nil
+		}
+
+		affiliation, err := t.check_affiliation(stub)
+
+		if err == nil {
+			req.Affiliation, _ = s.participant_role(affiliation)
+		}
+
+		return nil
+	}
+
+	user, affiliation, err := t.get_caller_data(stub)
+
+	if err != nil {
+		return new_error(ERR_UNAUTHENTICATED, "Error retrieving caller information: "+err.Error())
+	}
+
+	if user == "" {
+		return new_error(ERR_UNAUTHENTICATED, "Caller certificate has no 'username' attribute")
+	}
+
+	role, ok := s.participant_role(affiliation)
+
+	if !ok {
+		return new_error(ERR_UNAUTHENTICATED, "Caller certificate role '"+affiliation+"' is not a known participant role")
+	}
+
+	if req.Asset.Caller != "" && req.Asset.Caller != user {
+		return new_error(ERR_PERMISSION_DENIED, "Permission Denied. Payload caller "+req.Asset.Caller+" does not match certificate user "+user).on_field("asset.caller")
+	}
+
+	req.Caller = user
+	req.Affiliation = role
+	req.Asset.Caller = user
+
+	return nil
+}
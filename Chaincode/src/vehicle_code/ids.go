@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Asset ID modes - ASSET_IDS_CLIENT requires the caller of createAsset to pass the asset ID, ASSET_IDS_ALLOCATE
+//					  issues the next ID of the ledger's sequence when none is passed.
+//==============================================================================================================================
+const ASSET_IDS_CLIENT = "client"
+const ASSET_IDS_ALLOCATE = "allocate"
+
+//==============================================================================================================================
+//	 Check digits - The last digit of an asset ID is either part of the number or a Luhn check digit over it.
+//==============================================================================================================================
+const CHECK_DIGIT_NONE = "none"
+const CHECK_DIGIT_LUHN = "luhn"
+
+//==============================================================================================================================
+//	 Asset ID format limits - The prefix and the number of digits following it, check digit included.
+//==============================================================================================================================
+const PATTERN_ASSET_ID_PREFIX = "^[A-Za-z0-9]{0,8}$"
+const MIN_ASSET_ID_WIDTH = 4
+const MAX_ASSET_ID_WIDTH = 16
+
+//==============================================================================================================================
+//	 ASSET_ID_SEQUENCE_KEY - World state key of the sequence allocated asset IDs are numbered from.
+//==============================================================================================================================
+const ASSET_ID_SEQUENCE_KEY = "sequence~assetID"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	AssetIdSequence - The number the next allocated asset ID is made from.
+//==============================================================================================================================
+type AssetIdSequence struct {
+	Next int64 `json:"next"`
+}
+
+//==============================================================================================================================
+//	 luhn_digit - Returns the Luhn check digit of the digits passed.
+//==============================================================================================================================
+func luhn_digit(digits string) byte {
+
+	sum, double := 0, true
+
+	for i := len(digits) - 1; i >= 0; i-- {
+
+		d := int(digits[i] - '0')
+
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+//==============================================================================================================================
+//	 asset_id_digits - Returns how many digits of an asset ID hold the number, i.e. the width less the check digit.
+//==============================================================================================================================
+func (s Settings) asset_id_digits() int {
+
+	if s.AssetIdCheckDigit == CHECK_DIGIT_LUHN {
+		return s.AssetIdWidth - 1
+	}
+
+	return s.AssetIdWidth
+}
+
+//==============================================================================================================================
+//	 asset_id_pattern - Returns the pattern asset IDs of the deployment follow.
+//==============================================================================================================================
+func (s Settings) asset_id_pattern() string {
+
+	return fmt.Sprintf("^%v[0-9]{%v}$", regexp.QuoteMeta(s.AssetIdPrefix), s.AssetIdWidth)
+}
+
+//==============================================================================================================================
+//	 format_asset_id - Returns the asset ID of the number passed, or false if the number needs more digits than the
+//					   format has.
+//==============================================================================================================================
+func (s Settings) format_asset_id(n int64) (string, bool) {
+
+	digits := fmt.Sprintf("%0*d", s.asset_id_digits(), n)
+
+	if len(digits) > s.asset_id_digits() {
+		return "", false
+	}
+
+	if s.AssetIdCheckDigit == CHECK_DIGIT_LUHN {
+		digits += string(luhn_digit(digits))
+	}
+
+	return s.AssetIdPrefix + digits, true
+}
+
+//==============================================================================================================================
+//	 check_asset_id - Checks an asset ID passed by a client against the format of the deployment, check digit included.
+//==============================================================================================================================
+func (s Settings) check_asset_id(v5cID string) error {
+
+	matched, err := regexp.MatchString(s.asset_id_pattern(), v5cID)
+
+	if err != nil || !matched {
+		return new_error(ERR_INVALID_INPUT, "Invalid assetID "+v5cID+", expected "+s.asset_id_pattern()).on_field("asset.assetID")
+	}
+
+	if s.AssetIdCheckDigit == CHECK_DIGIT_LUHN {
+
+		digits := strings.TrimPrefix(v5cID, s.AssetIdPrefix)
+
+		if luhn_digit(digits[:len(digits)-1]) != digits[len(digits)-1] {
+			return new_error(ERR_INVALID_INPUT, "Invalid assetID "+v5cID+", wrong check digit").on_field("asset.assetID")
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 retrieve_id_sequence - Reads the asset ID sequence from the ledger. It starts at 1.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_id_sequence(stub shim.ChaincodeStubInterface) (AssetIdSequence, error) {
+
+	seq := AssetIdSequence{Next: 1}
+
+	bytes, err := stub.GetState(ASSET_ID_SEQUENCE_KEY)
+
+	if err != nil {
+		return seq, errors.New("Unable to get asset ID sequence")
+	}
+
+	if bytes == nil {
+		return seq, nil
+	}
+
+	err = json.Unmarshal(bytes, &seq)
+
+	if err != nil {
+		return seq, errors.New("Corrupt asset ID sequence record")
+	}
+
+	return seq, nil
+}
+
+//==============================================================================================================================
+//	 save_id_sequence - Writes the asset ID sequence to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_id_sequence(stub shim.ChaincodeStubInterface, seq AssetIdSequence) error {
+
+	bytes, err := json.Marshal(seq)
+
+	if err != nil {
+		return errors.New("Error converting asset ID sequence record")
+	}
+
+	err = stub.PutState(ASSET_ID_SEQUENCE_KEY, bytes)
+
+	if err != nil {
+		return errors.New("Error storing asset ID sequence record")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 next_asset_id - Advances seq to the next asset ID not taken on the ledger or listed in reserved, and returns it.
+//					 IDs taken by clients are skipped. seq is only advanced in memory; the caller saves it once the
+//					 asset is stored.
+//==============================================================================================================================
+func (t *SimpleChaincode) next_asset_id(stub shim.ChaincodeStubInterface, s Settings, seq *AssetIdSequence, reserved map[string]bool) (string, error) {
+
+	for {
+
+		v5cID, ok := s.format_asset_id(seq.Next)
+
+		if !ok {
+			return "", new_error(ERR_INVALID_STATE, fmt.Sprintf("No asset IDs left, %v digits are used up", s.asset_id_digits())).on_field("asset.assetID")
+		}
+
+		seq.Next++
+
+		if reserved[v5cID] {
+			continue
+		}
+
+		record, err := stub.GetState(v5cID)
+
+		if err != nil {
+			return "", errors.New("Unable to check asset ID " + v5cID)
+		}
+
+		if record == nil {
+			return v5cID, nil
+		}
+	}
+}
+
+//==============================================================================================================================
+//	 resolve_asset_id - Returns the asset ID passed or, if none is and the deployment allocates IDs, the next one of seq.
+//==============================================================================================================================
+func (t *SimpleChaincode) resolve_asset_id(stub shim.ChaincodeStubInterface, s Settings, v5cID string, seq *AssetIdSequence, reserved map[string]bool) (string, error) {
+
+	if v5cID != "" {
+		return v5cID, nil
+	}
+
+	if s.AssetIdMode != ASSET_IDS_ALLOCATE {
+		return "", new_error(ERR_INVALID_INPUT, "asset.assetID is required, this deployment does not allocate asset IDs").on_field("asset.assetID")
+	}
+
+	return t.next_asset_id(stub, s, seq, reserved)
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestLuhnDigit(t *testing.T) {
+
+	cases := []struct {
+		digits string
+		want   byte
+	}{
+		{"7992739871", '3'},
+		{"0", '0'},
+		{"1", '8'},
+		{"18", '2'},
+		{"0000001", '8'},
+		{"0000000", '0'},
+	}
+
+	for _, c := range cases {
+		if got := luhn_digit(c.digits); got != c.want {
+			t.Errorf("luhn_digit(%q) = %c, want %c", c.digits, got, c.want)
+		}
+	}
+}
+
+func TestFormatAssetId(t *testing.T) {
+
+	luhn := Settings{AssetIdPrefix: "TRK", AssetIdWidth: 8, AssetIdCheckDigit: CHECK_DIGIT_LUHN}
+	plain := Settings{AssetIdPrefix: "", AssetIdWidth: 4, AssetIdCheckDigit: CHECK_DIGIT_NONE}
+
+	cases := []struct {
+		name     string
+		settings Settings
+		n        int64
+		want     string
+		ok       bool
+	}{
+		{"check digit appended", luhn, 1, "TRK00000018", true},
+		{"largest number", luhn, 9999999, "TRK99999997", true},
+		{"number too long", luhn, 10000000, "", false},
+		{"no check digit", plain, 42, "0042", true},
+		{"number too long without check digit", plain, 10000, "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := c.settings.format_asset_id(c.n)
+
+		if got != c.want || ok != c.ok {
+			t.Errorf("%v: format_asset_id(%v) = %q, %v, want %q, %v", c.name, c.n, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestCheckAssetId(t *testing.T) {
+
+	luhn := Settings{AssetIdPrefix: "TRK", AssetIdWidth: 8, AssetIdCheckDigit: CHECK_DIGIT_LUHN}
+	plain := Settings{AssetIdPrefix: "TRK", AssetIdWidth: 8, AssetIdCheckDigit: CHECK_DIGIT_NONE}
+
+	cases := []struct {
+		name     string
+		settings Settings
+		v5cID    string
+		valid    bool
+	}{
+		{"valid check digit", luhn, "TRK00000018", true},
+		{"wrong check digit", luhn, "TRK00000017", false},
+		{"wrong prefix", luhn, "ABC00000018", false},
+		{"too few digits", luhn, "TRK0000018", false},
+		{"too many digits", luhn, "TRK000000018", false},
+		{"letters among the digits", luhn, "TRK0000A018", false},
+		{"prefix is case sensitive", luhn, "trk00000018", false},
+		{"any last digit without check digit", plain, "TRK00000017", true},
+	}
+
+	for _, c := range cases {
+
+		err := c.settings.check_asset_id(c.v5cID)
+
+		if c.valid {
+			if err != nil {
+				t.Errorf("%v: check_asset_id(%q) = %v, want no error", c.name, c.v5cID, err)
+			}
+			continue
+		}
+
+		e, ok := err.(ChaincodeError)
+
+		if !ok || e.Code != ERR_INVALID_INPUT || e.Field != "asset.assetID" {
+			t.Errorf("%v: check_asset_id(%q) = %v, want an %v error on asset.assetID", c.name, c.v5cID, err, ERR_INVALID_INPUT)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 ASSET_INDEX_PREFIX - Every asset has an index key ASSET_INDEX_PREFIX<assetID>. Listing the assets is a range query
+//						  over these keys, so creating an asset no longer rewrites one shared record.
+//==============================================================================================================================
+const ASSET_INDEX_PREFIX = "asset~"
+
+//==============================================================================================================================
+//	 CREATED_INDEX_PREFIX - Every asset also has a key CREATED_INDEX_PREFIX<creation time>~<assetID> holding its ID, so
+//							the assets are listed by creation time with a range query as well. Assets migrated from the
+//							v5cIDs array have no creation time and come first.
+//==============================================================================================================================
+const CREATED_INDEX_PREFIX = "created~"
+
+//==============================================================================================================================
+//	 LEGACY_INDEX_KEY - The key of the V5C_Holder array used as index by earlier versions of the chaincode.
+//==============================================================================================================================
+const LEGACY_INDEX_KEY = "v5cIDs"
+
+//==============================================================================================================================
+//	 RANGE_END - Appended to a prefix to get the end key of a range query covering every key with that prefix. IDs
+//				 and index values are printable ASCII, which all sort before it.
+//==============================================================================================================================
+const RANGE_END = "\x7f"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	IndexEntry - One key found by a range query, with the prefix removed, and its value.
+//==============================================================================================================================
+type IndexEntry struct {
+	Key   string
+	Value string
+}
+
+//==============================================================================================================================
+//	 range_entries - Returns, sorted by key, the keys starting with prefix with the prefix removed and their values. The
+//					 keys are checked against the prefix as the bounds are inclusive.
+//==============================================================================================================================
+func range_entries(stub shim.ChaincodeStubInterface, prefix string) ([]IndexEntry, error) {
+
+	iter, err := stub.RangeQueryState(prefix, prefix+RANGE_END)
+
+	if err != nil {
+		return nil, errors.New("Unable to query keys " + prefix + ": " + err.Error())
+	}
+
+	defer iter.Close()
+
+	entries := []IndexEntry{}
+
+	for iter.HasNext() {
+
+		key, value, err := iter.Next()
+
+		if err != nil {
+			return nil, errors.New("Unable to query keys " + prefix + ": " + err.Error())
+		}
+
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, IndexEntry{Key: strings.TrimPrefix(key, prefix), Value: string(value)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	return entries, nil
+}
+
+//==============================================================================================================================
+//	 scan_entries - Calls visit, in key order, for the keys starting with prefix from the key prefix+from on, until
+//					visit returns false. Unlike range_entries it stops reading the range there, so a page of a large
+//					index does not load the whole index.
+//==============================================================================================================================
+func scan_entries(stub shim.ChaincodeStubInterface, prefix string, from string, visit func(IndexEntry) (bool, error)) error {
+
+	iter, err := stub.RangeQueryState(prefix+from, prefix+RANGE_END)
+
+	if err != nil {
+		return errors.New("Unable to query keys " + prefix + ": " + err.Error())
+	}
+
+	defer iter.Close()
+
+	for iter.HasNext() {
+
+		key, value, err := iter.Next()
+
+		if err != nil {
+			return errors.New("Unable to query keys " + prefix + ": " + err.Error())
+		}
+
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		more, err := visit(IndexEntry{Key: strings.TrimPrefix(key, prefix), Value: string(value)})
+
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 range_keys - As range_entries, returning the keys only.
+//==============================================================================================================================
+func range_keys(stub shim.ChaincodeStubInterface, prefix string) ([]string, error) {
+
+	entries, err := range_entries(stub, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+
+	return keys, nil
+}
+
+//==============================================================================================================================
+//	 add_asset_index - Writes the index keys of the asset passed. The value of its ASSET_INDEX_PREFIX key is the creation
+//					   time of the asset, RFC3339, empty for assets migrated from the v5cIDs array.
+//==============================================================================================================================
+func (t *SimpleChaincode) add_asset_index(stub shim.ChaincodeStubInterface, v5cID string, created string) error {
+
+	err := stub.PutState(ASSET_INDEX_PREFIX+v5cID, []byte(created))
+
+	if err != nil {
+		return errors.New("Unable to index asset " + v5cID)
+	}
+
+	err = stub.PutState(CREATED_INDEX_PREFIX+created+"~"+v5cID, []byte(v5cID))
+
+	if err != nil {
+		return errors.New("Unable to index asset " + v5cID)
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 has_assets - Returns true if the ledger holds an index key of any asset.
+//==============================================================================================================================
+func (t *SimpleChaincode) has_assets(stub shim.ChaincodeStubInterface) (bool, error) {
+
+	found := false
+
+	err := scan_entries(stub, ASSET_INDEX_PREFIX, "", func(e IndexEntry) (bool, error) {
+		found = true
+		return false, nil
+	})
+
+	return found, err
+}
+
+//==============================================================================================================================
+//	 retrieve_legacy_index - Reads the legacy v5cIDs array. A ledger without one has no IDs left to move.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_legacy_index(stub shim.ChaincodeStubInterface) (V5C_Holder, error) {
+
+	var v5cIDs V5C_Holder
+
+	bytes, err := stub.GetState(LEGACY_INDEX_KEY)
+
+	if err != nil {
+		return v5cIDs, errors.New("Unable to get v5cIDs")
+	}
+
+	if bytes == nil {
+		return v5cIDs, nil
+	}
+
+	err = json.Unmarshal(bytes, &v5cIDs)
+
+	if err != nil {
+		return v5cIDs, errors.New("Corrupt V5C_Holder record")
+	}
+
+	return v5cIDs, nil
+}
+
+//==============================================================================================================================
+//	 move_legacy_index - Moves up to n IDs from the legacy v5cIDs array to index keys and writes the rest back, or
+//						 removes the array once it is empty. Returns how many IDs were moved and how many are left.
+//==============================================================================================================================
+func (t *SimpleChaincode) move_legacy_index(stub shim.ChaincodeStubInterface, n int) (int, int, error) {
+
+	v5cIDs, err := t.retrieve_legacy_index(stub)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	batch := v5cIDs.V5Cs
+
+	if len(batch) > n {
+		batch = batch[:n]
+	}
+
+	for _, v5cID := range batch {
+
+		err = t.add_asset_index(stub, v5cID, "")
+
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	v5cIDs.V5Cs = v5cIDs.V5Cs[len(batch):]
+
+	if len(v5cIDs.V5Cs) == 0 {
+
+		err = stub.DelState(LEGACY_INDEX_KEY)
+
+	} else {
+
+		var bytes []byte
+
+		bytes, err = json.Marshal(v5cIDs)
+
+		if err != nil {
+			return 0, 0, errors.New("Error creating V5C_Holder record")
+		}
+
+		err = stub.PutState(LEGACY_INDEX_KEY, bytes)
+	}
+
+	if err != nil {
+		return 0, 0, errors.New("Unable to update v5cIDs")
+	}
+
+	return len(batch), len(v5cIDs.V5Cs), nil
+}
+
+//=================================================================================================================================
+//	 migrateAssetIndex - Moves up to a page of IDs from the legacy v5cIDs array to index keys. The remaining IDs are
+//						 written back, so the regulator calls it until remaining is 0; the array is then removed.
+//						 migrate does the same as the first step of a schema upgrade.
+//=================================================================================================================================
+func (t *SimpleChaincode) migrateAssetIndex(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, page PageRequest) ([]byte, error) {
+
+	if caller_affiliation != REGULATOR {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. migrateAssetIndex. Only a REGULATOR may migrate the asset index")
+	}
+
+	migrated, remaining, err := t.move_legacy_index(stub, page.page_size())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Migrated  int `json:"migrated"`
+		Remaining int `json:"remaining"`
+	}{migrated, remaining})
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Truck status types - The truck lifecycle is broken down into 8 statuses. A truck only moves from one to the next
+//						  through transitionAsset, following the transitions table below.
+//==============================================================================================================================
+const STATUS_PO_CREATED = "PO_CREATED"
+const STATUS_IN_PRODUCTION = "IN_PRODUCTION"
+const STATUS_SUPPLIER_TESTED = "SUPPLIER_TESTED"
+const STATUS_SHIPPED_TO_DMA = "SHIPPED_TO_DMA"
+const STATUS_DMA_RECEIVED = "DMA_RECEIVED"
+const STATUS_DMA_TESTED = "DMA_TESTED"
+const STATUS_DELIVERED_TO_AF = "DELIVERED_TO_AF"
+const STATUS_AF_ACCEPTED = "AF_ACCEPTED"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	Transition - One allowed move of a lifecycle. Only Roles may make it and only once every field in Required holds
+//				 a value. Moves of the car lifecycle are made by the chaincode function named in Function and hand
+//				 the car to a participant of the Recipient role, if one is set; see car_lifecycle.
+//==============================================================================================================================
+type Transition struct {
+	From      string   `json:"from"`
+	To        string   `json:"to"`
+	Roles     []string `json:"roles"`
+	Required  []string `json:"required"`
+	Function  string   `json:"function,omitempty"`
+	Recipient string   `json:"recipient,omitempty"`
+}
+
+//==============================================================================================================================
+//	AllowedTransition - A transition as reported by readAllowedTransitions: whether the caller's role may make it and
+//						which required fields are still empty.
+//==============================================================================================================================
+type AllowedTransition struct {
+	Transition
+	Allowed bool     `json:"allowed"`
+	Missing []string `json:"missing"`
+}
+
+//==============================================================================================================================
+//	 transitions - The truck lifecycle. PO created -> in production -> supplier tested -> shipped to DMA ->
+//				   DMA goods received -> DMA tested -> delivered to AF -> AF accepted.
+//==============================================================================================================================
+var transitions = []Transition{
+	{From: STATUS_PO_CREATED, To: STATUS_IN_PRODUCTION, Roles: []string{SUPPLIER}, Required: []string{"poDma", "poSupp"}},
+	{From: STATUS_IN_PRODUCTION, To: STATUS_SUPPLIER_TESTED, Roles: []string{SUPPLIER}, Required: []string{"truckMod", "truckPdate", "truckChnum", "truckEnnum", "suppTest"}},
+	{From: STATUS_SUPPLIER_TESTED, To: STATUS_SHIPPED_TO_DMA, Roles: []string{SUPPLIER, TRANSPORTER}, Required: []string{"dmaDelDate"}},
+	{From: STATUS_SHIPPED_TO_DMA, To: STATUS_DMA_RECEIVED, Roles: []string{DMA}, Required: []string{"grDma"}},
+	{From: STATUS_DMA_RECEIVED, To: STATUS_DMA_TESTED, Roles: []string{DMA}, Required: []string{"dmaMasdat", "afDmaTest"}},
+	{From: STATUS_DMA_TESTED, To: STATUS_DELIVERED_TO_AF, Roles: []string{DMA, TRANSPORTER}, Required: []string{"dmaDelCert", "afDelDate"}},
+	{From: STATUS_DELIVERED_TO_AF, To: STATUS_AF_ACCEPTED, Roles: []string{AF}, Required: []string{"grAf"}},
+}
+
+//==============================================================================================================================
+//	 asset_status - Returns the status of an asset. Truck records written before trucks carried a status get one
+//					derived from the latest milestone recorded on them.
+//==============================================================================================================================
+func asset_status(v Vehicle) string {
+
+	if v.Status != "" {
+		return v.Status
+	}
+
+	if v.asset_type() == ASSET_CAR {
+		return CAR_TEMPLATE
+	}
+
+	switch {
+	case v.GrAf != "":
+		return STATUS_AF_ACCEPTED
+	case v.AfDmaTest != "":
+		return STATUS_DMA_TESTED
+	case v.GrDma != "":
+		return STATUS_DMA_RECEIVED
+	case v.SuppTest != "":
+		return STATUS_SUPPLIER_TESTED
+	}
+
+	return STATUS_PO_CREATED
+}
+
+//==============================================================================================================================
+//	 find_transition - Looks up the transition of lifecycle between the two statuses passed.
+//==============================================================================================================================
+func find_transition(lifecycle []Transition, from string, to string) (Transition, bool) {
+
+	for _, tr := range lifecycle {
+		if tr.From == from && tr.To == to {
+			return tr, true
+		}
+	}
+
+	return Transition{}, false
+}
+
+//==============================================================================================================================
+//	 find_field - Looks up the asset field with the JSON name passed.
+//==============================================================================================================================
+func find_field(name string) (AssetField, bool) {
+
+	for _, f := range asset_fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return AssetField{}, false
+}
+
+//==============================================================================================================================
+//	 field_value - Returns the value of the field of v named, a field of the Vehicle or, named with ATTRIBUTE_PREFIX, a
+//				   field of its asset type definition.
+//==============================================================================================================================
+func field_value(v Vehicle, name string) string {
+
+	if strings.HasPrefix(name, ATTRIBUTE_PREFIX) {
+		return v.Attributes[strings.TrimPrefix(name, ATTRIBUTE_PREFIX)]
+	}
+
+	f, ok := find_field(name)
+
+	if !ok {
+		return ""
+	}
+
+	return *f.Value(&v)
+}
+
+//==============================================================================================================================
+//	 missing_fields - Returns the fields of required that are empty on v.
+//==============================================================================================================================
+func missing_fields(v Vehicle, required []string) []string {
+
+	missing := []string{}
+
+	for _, name := range required {
+
+		if field_value(v, name) == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+//=================================================================================================================================
+//	 transitionAsset - Moves a truck, or an asset of a registered type, to the status passed in the request. The
+//					   request may carry the fields the transition requires; they are checked against the permission
+//					   matrix like in updateAsset.
+//=================================================================================================================================
+func (t *SimpleChaincode) transitionAsset(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, animals Animal) ([]byte, error) {
+
+	if v.asset_type() == ASSET_CAR {
+		return nil, new_error(ERR_INVALID_STATE, "Invalid transition. transitionAsset asset "+v.V5cID+" is a car, cars move through authority_to_manufacturer, manufacturer_to_private etc.").on_asset(v.V5cID)
+	}
+
+	def, err := t.asset_definition(stub, v.asset_type())
+
+	if err != nil {
+		return nil, err
+	}
+
+	from := asset_status(v)
+
+	tr, ok := find_transition(def.lifecycle(), from, animals.Status)
+
+	if !ok {
+		return nil, new_error(ERR_INVALID_STATE, fmt.Sprintf("Invalid transition. transitionAsset asset %v can not move from %v to %v", v.V5cID, from, animals.Status)).on_field("asset.status")
+	}
+
+	if !contains(tr.Roles, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. transitionAsset %v -> %v may only be made by %v, caller:%v role:%v", tr.From, tr.To, tr.Roles, caller, caller_affiliation))
+	}
+
+	if errs := def.check_attributes(animals.Attributes, "asset."+ATTRIBUTE_PREFIX, false); len(errs) > 0 {
+		return nil, invalid_input("transitionAsset", "Invalid attributes", errs)
+	}
+
+	update := def.as_vehicle(animals)
+	changed := changed_fields(v, update)
+	forbidden := forbidden_fields(changed, v, caller, caller_affiliation, def.permission_matrix())
+
+	if len(forbidden) > 0 {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. transitionAsset caller:%v role:%v status:%v may not write fields: %v", caller, caller_affiliation, from, strings.Join(forbidden, ", ")))
+	}
+
+	apply_fields(&v, update, changed)
+
+	missing := missing_fields(v, tr.Required)
+
+	if len(missing) > 0 {
+		return nil, new_error(ERR_INVALID_INPUT, fmt.Sprintf("Transition incomplete. transitionAsset %v -> %v requires fields: %v", tr.From, tr.To, strings.Join(missing, ", ")))
+	}
+
+	v.Status = tr.To
+	v.Caller = caller
+	v.AssetId = v.V5cID
+
+	err = t.validate_dates(stub, v, "transitionAsset")
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = t.save_changes(stub, v, caller, "transitionAsset")
+
+	if err != nil {
+		return nil, wrap_error("Error saving changes", err)
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 readAllowedTransitions - Lists the transitions leading out of the asset's current status, whether the caller's
+//							  role may make each one and which of its required fields are still empty. The moves of a
+//							  car are only open to its owner. The owner and the regulator see every transition, other
+//							  participants only those their role may make.
+//=================================================================================================================================
+func (t *SimpleChaincode) readAllowedTransitions(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+
+	def, err := t.asset_definition(stub, v.asset_type())
+
+	if err != nil {
+		return nil, err
+	}
+
+	status := asset_status(v)
+	sees_all := v.OwnerId == caller || caller_affiliation == REGULATOR
+
+	allowed := []AllowedTransition{}
+
+	for _, tr := range def.lifecycle() {
+
+		if tr.From != status || (!sees_all && !contains(tr.Roles, caller_affiliation)) {
+			continue
+		}
+
+		may := contains(tr.Roles, caller_affiliation) && (v.asset_type() != ASSET_CAR || v.OwnerId == caller)
+		allowed = append(allowed, AllowedTransition{Transition: tr, Allowed: may, Missing: missing_fields(v, tr.Required)})
+	}
+
+	if !sees_all && len(allowed) == 0 {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readAllowedTransitions. The caller should be owner, Regulator or of a role that may move the asset on.").on_asset(v.V5cID)
+	}
+
+	bytes, err := json.Marshal(struct {
+		AssetId     string              `json:"assetID"`
+		Status      string              `json:"status"`
+		Transitions []AllowedTransition `json:"transitions"`
+	}{v.V5cID, status, allowed})
+
+	if err != nil {
+		return nil, errors.New("READALLOWEDTRANSITIONS: Error converting transitions")
+	}
+
+	return bytes, nil
+}
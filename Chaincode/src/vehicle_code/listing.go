@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Sort orders of readAllAssets
+//==============================================================================================================================
+const SORT_BY_ASSET_ID = "assetID"
+const SORT_BY_CREATED = "createdAt"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	AssetFilter - The "filter" object of the JSON argument of readAllAssets. Empty fields do not filter. Date ranges
+//				  are inclusive and either end may be left open.
+//==============================================================================================================================
+type AssetFilter struct {
+	AssetType       string `json:"assetType"`
+	OwnerId         string `json:"ownerId"`
+	TruckMod        string `json:"truckMod"`
+	MatnrAf         string `json:"matnrAf"`
+	TransactionType string `json:"transactionType"`
+	DmaDelDateFrom  string `json:"dmaDelDateFrom"`
+	DmaDelDateTo    string `json:"dmaDelDateTo"`
+	AfDelDateFrom   string `json:"afDelDateFrom"`
+	AfDelDateTo     string `json:"afDelDateTo"`
+}
+
+//==============================================================================================================================
+//	 in_range - Returns true if value lies between from and to. An empty bound is open, an empty value is only in an
+//				open range.
+//==============================================================================================================================
+func in_range(value string, from string, to string) bool {
+
+	if from == "" && to == "" {
+		return true
+	}
+
+	if value == "" {
+		return false
+	}
+
+	return (from == "" || value >= from) && (to == "" || value <= to)
+}
+
+//==============================================================================================================================
+//	 matches - Returns true if v passes every filter set. Date bounds must be ISO dates, see normalise_dates.
+//==============================================================================================================================
+func (f AssetFilter) matches(v Vehicle) bool {
+
+	return (f.AssetType == "" || v.asset_type() == f.AssetType) &&
+		(f.OwnerId == "" || v.OwnerId == f.OwnerId) &&
+		(f.TruckMod == "" || v.TruckMod == f.TruckMod) &&
+		(f.MatnrAf == "" || v.MatnrAf == f.MatnrAf) &&
+		(f.TransactionType == "" || v.TransactionType == f.TransactionType) &&
+		in_range(normal_date(v.DmaDelDate), f.DmaDelDateFrom, f.DmaDelDateTo) &&
+		in_range(normal_date(v.AfDelDate), f.AfDelDateFrom, f.AfDelDateTo)
+}
+
+//==============================================================================================================================
+//	 sort_indexes - The index each sort order pages through. The keys of an index, prefix removed, are the sort keys;
+//					the bookmark of a page is the key of its last asset. Creation times are not unique, the asset ID
+//					in the CREATED_INDEX_PREFIX keys breaks ties and their value is the asset ID.
+//==============================================================================================================================
+var sort_indexes = map[string]string{
+	SORT_BY_ASSET_ID: ASSET_INDEX_PREFIX,
+	SORT_BY_CREATED:  CREATED_INDEX_PREFIX,
+}
+
+//=================================================================================================================================
+//	 readAllAssets - Returns a page of the assets passing the filter, sorted by asset ID or by creation time. The
+//					 regulator sees every asset, other participants the assets they own. Document bodies are left
+//					 out as in get_vehicles. A record that can not be read, a car record not migrated yet or a corrupt
+//					 one, does not fail the page: it is left out and the regulator gets its error in the page errors.
+//					 In both formats the response is a PageEnvelope, an object: unlike get_vehicles, and the array of
+//					 asset envelopes readAllAssets first returned, the legacy format is no bare array, as the page
+//					 needs its bookmark.
+//=================================================================================================================================
+func (t *SimpleChaincode) readAllAssets(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, filter AssetFilter, page PageRequest, format string) ([]byte, error) {
+
+	sort_by := page.SortBy
+
+	if sort_by == "" {
+		sort_by = SORT_BY_ASSET_ID
+	}
+
+	index, ok := sort_indexes[sort_by]
+
+	if !ok {
+		return nil, new_error(ERR_INVALID_INPUT, "Invalid sortBy "+sort_by+", expected "+SORT_BY_ASSET_ID+" or "+SORT_BY_CREATED).on_field("page.sortBy")
+	}
+
+	if errs := filter.normalise_dates(); len(errs) > 0 {
+		return nil, invalid_input("readAllAssets", "Invalid filter", errs)
+	}
+
+	assets := []json.RawMessage{}
+	failed := []ChaincodeError{}
+	last, bookmark := "", ""
+
+	visit := func(e IndexEntry) (bool, error) {
+
+		if page.Bookmark != "" && e.Key <= page.Bookmark {
+			return true, nil
+		}
+
+		v5cID := e.Key
+
+		if sort_by == SORT_BY_CREATED {
+			v5cID = e.Value
+		}
+
+		v, err := t.retrieve_v5c(stub, v5cID)
+
+		if err != nil {
+
+			unreadable, ok := err.(ChaincodeError)
+
+			if !ok {
+				return false, err
+			}
+
+			// A record past a full page is reported with the next page
+
+			if len(assets) < page.page_size() {
+
+				if caller_affiliation == REGULATOR {
+					failed = append(failed, unreadable)
+				}
+
+				last = e.Key
+			}
+
+			return true, nil
+		}
+
+		if caller_affiliation != REGULATOR && v.OwnerId != caller {
+			return true, nil
+		}
+
+		if !filter.matches(v) {
+			return true, nil
+		}
+
+		if len(assets) == page.page_size() {
+			bookmark = last
+			return false, nil
+		}
+
+		asset, err := t.get_vehicle_details2(stub, v, caller, caller_affiliation, format)
+
+		if err != nil {
+			return false, err
+		}
+
+		assets = append(assets, asset)
+		last = e.Key
+
+		return true, nil
+	}
+
+	// The scan starts at the bookmark and ends with the page
+
+	if err := scan_entries(stub, index, page.Bookmark, visit); err != nil {
+		return nil, err
+	}
+
+	return t.render_page(stub, assets, failed, bookmark, format)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 LOOKUP_PREFIX - Business keys are indexed under LOOKUP_PREFIX<field>~<value>~<assetID>, so the assets carrying a
+//					 value are found with a range query over LOOKUP_PREFIX<field>~<value>~.
+//==============================================================================================================================
+const LOOKUP_PREFIX = "lookup~"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	LookupIndex - A business key the SAP integration knows trucks by, or the VIN of a car. A Unique key may be carried
+//				  by one asset only.
+//==============================================================================================================================
+type LookupIndex struct {
+	Field  string
+	Unique bool
+}
+
+//==============================================================================================================================
+//	 lookup_indexes - The business keys indexed on every write of an asset.
+//==============================================================================================================================
+var lookup_indexes = []LookupIndex{
+	{Field: "poDma"},
+	{Field: "poSupp"},
+	{Field: "truckChnum", Unique: true},
+	{Field: "truckEnnum", Unique: true},
+	{Field: "matnrAf"},
+	{Field: "VIN", Unique: true},
+}
+
+//==============================================================================================================================
+//	 is_lookup_field - Returns true if the field passed is one of the business keys.
+//==============================================================================================================================
+func is_lookup_field(field string) bool {
+
+	for _, index := range lookup_indexes {
+		if index.Field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+//==============================================================================================================================
+//	 lookup_prefix - Returns the prefix of the index keys of the assets whose field holds value.
+//==============================================================================================================================
+func lookup_prefix(field string, value string) string {
+
+	return LOOKUP_PREFIX + field + "~" + value + "~"
+}
+
+//==============================================================================================================================
+//	 lookup_asset_ids - Returns the IDs of the assets whose field holds value.
+//==============================================================================================================================
+func (t *SimpleChaincode) lookup_asset_ids(stub shim.ChaincodeStubInterface, field string, value string) ([]string, error) {
+
+	return range_keys(stub, lookup_prefix(field, value))
+}
+
+//==============================================================================================================================
+//	 check_lookups - Checks the business keys changed between before and after without writing anything. Fails if
+//					 after takes a unique key already carried by another asset, or a key not matching its configured
+//					 pattern, see Settings.IdPatterns.
+//==============================================================================================================================
+func (t *SimpleChaincode) check_lookups(stub shim.ChaincodeStubInterface, before Vehicle, after Vehicle) error {
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return err
+	}
+
+	for _, index := range lookup_indexes {
+
+		f, _ := find_field(index.Field)
+
+		old_value, new_value := *f.Value(&before), *f.Value(&after)
+
+		if old_value == new_value || new_value == "" {
+			continue
+		}
+
+		if strings.Contains(new_value, "~") {
+			return new_error(ERR_INVALID_INPUT, "Invalid "+index.Field+" "+new_value+", '~' is not allowed").on_field("asset." + index.Field)
+		}
+
+		if pattern := settings.IdPatterns[index.Field]; pattern != "" {
+
+			matched, err := regexp.MatchString(pattern, new_value)
+
+			if err != nil || !matched {
+				return new_error(ERR_INVALID_INPUT, "Invalid "+index.Field+" "+new_value+", expected "+pattern).on_field("asset." + index.Field)
+			}
+		}
+
+		if !index.Unique {
+			continue
+		}
+
+		v5cIDs, err := t.lookup_asset_ids(stub, index.Field, new_value)
+
+		if err != nil {
+			return err
+		}
+
+		for _, v5cID := range v5cIDs {
+			if v5cID != after.V5cID {
+				return new_error(ERR_DUPLICATE_KEY, index.Field+" "+new_value+" is already used by asset "+v5cID).on_field("asset." + index.Field)
+			}
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 update_lookups - Moves the index keys of the business keys changed between before and after, once check_lookups
+//					  passes.
+//==============================================================================================================================
+func (t *SimpleChaincode) update_lookups(stub shim.ChaincodeStubInterface, before Vehicle, after Vehicle) error {
+
+	err := t.check_lookups(stub, before, after)
+
+	if err != nil {
+		return err
+	}
+
+	for _, index := range lookup_indexes {
+
+		f, _ := find_field(index.Field)
+
+		old_value, new_value := *f.Value(&before), *f.Value(&after)
+
+		if old_value == new_value {
+			continue
+		}
+
+		if old_value != "" {
+
+			err := stub.DelState(lookup_prefix(index.Field, old_value) + after.V5cID)
+
+			if err != nil {
+				return errors.New("Unable to remove " + index.Field + " index of " + after.V5cID)
+			}
+		}
+
+		if new_value == "" {
+			continue
+		}
+
+		err := stub.PutState(lookup_prefix(index.Field, new_value)+after.V5cID, []byte(after.V5cID))
+
+		if err != nil {
+			return errors.New("Unable to index " + index.Field + " of " + after.V5cID)
+		}
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 readAssetsBy - Returns the assets whose fields hold value, each as returned by readAsset. Assets the caller may not
+//					read are left out; if that leaves none the caller gets the readAsset permission error.
+//=================================================================================================================================
+func (t *SimpleChaincode) readAssetsBy(stub shim.ChaincodeStubInterface, fields []string, value string, caller string, caller_affiliation string, format string) ([]byte, error) {
+
+	if value == "" {
+		return nil, new_error(ERR_INVALID_INPUT, "No "+strings.Join(fields, " or ")+" passed")
+	}
+
+	v5cIDs := []string{}
+	seen := map[string]bool{}
+
+	for _, field := range fields {
+
+		found, err := t.lookup_asset_ids(stub, field, value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v5cID := range found {
+
+			// An asset holding the value in more than one field is listed once
+
+			if !seen[v5cID] {
+				seen[v5cID] = true
+				v5cIDs = append(v5cIDs, v5cID)
+			}
+		}
+	}
+
+	assets := []json.RawMessage{}
+
+	var denied error
+
+	for _, v5cID := range v5cIDs {
+
+		v, err := t.retrieve_v5c(stub, v5cID)
+
+		if err != nil {
+			return nil, wrap_error("Error retrieving v5c", err)
+		}
+
+		asset, err := t.get_vehicle_details(stub, v, caller, caller_affiliation, format)
+
+		if err != nil {
+			denied = err
+			continue
+		}
+
+		assets = append(assets, asset)
+	}
+
+	if len(assets) == 0 && denied != nil {
+		return nil, denied
+	}
+
+	bytes, err := json.Marshal(assets)
+
+	if err != nil {
+		return nil, errors.New("READASSETSBY: Error converting assets")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 readAssetByUniqueKey - Returns the one asset whose field holds value, as returned by readAsset.
+//=================================================================================================================================
+func (t *SimpleChaincode) readAssetByUniqueKey(stub shim.ChaincodeStubInterface, field string, value string, caller string, caller_affiliation string, format string) ([]byte, error) {
+
+	if value == "" {
+		return nil, new_error(ERR_INVALID_INPUT, "No "+field+" passed")
+	}
+
+	v5cIDs, err := t.lookup_asset_ids(stub, field, value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(v5cIDs) == 0 {
+		return nil, new_error(ERR_NOT_FOUND, "No asset with "+field+" "+value).on_field("asset." + field)
+	}
+
+	v, err := t.retrieve_v5c(stub, v5cIDs[0])
+
+	if err != nil {
+		return nil, wrap_error("Error retrieving v5c", err)
+	}
+
+	return t.get_vehicle_details(stub, v, caller, caller_affiliation, format)
+}
+
+//=================================================================================================================================
+//	 reindexAssets - Writes the business key indexes of up to a page of assets, for assets created before the indexes
+//					 existed. The regulator passes the returned bookmark back until it is empty.
+//=================================================================================================================================
+func (t *SimpleChaincode) reindexAssets(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, page PageRequest) ([]byte, error) {
+
+	if caller_affiliation != REGULATOR {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. reindexAssets. Only a REGULATOR may rebuild the indexes")
+	}
+
+	indexed, last, bookmark := 0, "", ""
+
+	err := scan_entries(stub, ASSET_INDEX_PREFIX, page.Bookmark, func(e IndexEntry) (bool, error) {
+
+		if e.Key <= page.Bookmark {
+			return true, nil
+		}
+
+		if indexed == page.page_size() {
+			bookmark = last
+			return false, nil
+		}
+
+		v, err := t.retrieve_v5c(stub, e.Key)
+
+		if err != nil {
+			return false, wrap_error("Error retrieving v5c", err)
+		}
+
+		err = t.update_lookups(stub, Vehicle{}, v)
+
+		if err != nil {
+			return false, err
+		}
+
+		indexed++
+		last = e.Key
+
+		return true, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Indexed  int    `json:"indexed"`
+		Bookmark string `json:"bookmark"`
+	}{indexed, bookmark})
+}
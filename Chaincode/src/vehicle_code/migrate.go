@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Schema versions - SCHEMA_LEGACY is the layout of the car chaincode: the v5cIDs array as index and car records.
+//					   SCHEMA_VERSION, the layout this chaincode reads and writes, has index keys and typed assets,
+//					   cars among them.
+//==============================================================================================================================
+const SCHEMA_LEGACY = 1
+const SCHEMA_VERSION = 3
+
+//==============================================================================================================================
+//	 SCHEMA_KEY - World state key of the schema record.
+//==============================================================================================================================
+const SCHEMA_KEY = "schema"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	SchemaState - The schema version the ledger is at and, while migrate is bringing it to SCHEMA_VERSION, how far it
+//				  has got: Bookmark is the last asset checked. Converted counts the car records converted.
+//==============================================================================================================================
+type SchemaState struct {
+	Version   int    `json:"version"`
+	Bookmark  string `json:"bookmark,omitempty"`
+	Converted int    `json:"converted,omitempty"`
+}
+
+//==============================================================================================================================
+//	LegacyVehicle - A record of the car chaincode.
+//==============================================================================================================================
+type LegacyVehicle struct {
+	Make            string `json:"make"`
+	Model           string `json:"model"`
+	Reg             string `json:"reg"`
+	VIN             int    `json:"VIN"`
+	Owner           string `json:"owner"`
+	Scrapped        bool   `json:"scrapped"`
+	Status          int    `json:"status"`
+	Colour          string `json:"colour"`
+	V5cID           string `json:"v5cID"`
+	LeaseContractID string `json:"leaseContractID"`
+}
+
+//==============================================================================================================================
+//	MigrationReport - The response of migrate. Indexed, Checked and Converted count what this call did, Remaining the
+//					  IDs still to move or check and TotalConverted the records converted by every call so far.
+//==============================================================================================================================
+type MigrationReport struct {
+	SchemaVersion  int  `json:"schemaVersion"`
+	TargetVersion  int  `json:"targetVersion"`
+	Indexed        int  `json:"indexed"`
+	Checked        int  `json:"checked"`
+	Converted      int  `json:"converted"`
+	TotalConverted int  `json:"totalConverted"`
+	Remaining      int  `json:"remaining"`
+	Done           bool `json:"done"`
+}
+
+//==============================================================================================================================
+//	 legacy_vehicle - Returns the car record held in bytes, or false if bytes is not one. Car records of the car
+//					  chaincode have a numeric status, assets a status string.
+//==============================================================================================================================
+func legacy_vehicle(bytes []byte) (LegacyVehicle, bool) {
+
+	var fields map[string]json.RawMessage
+	var v LegacyVehicle
+
+	if json.Unmarshal(bytes, &fields) != nil {
+		return v, false
+	}
+
+	status := strings.TrimSpace(string(fields["status"]))
+
+	if status == "" || status[0] == '"' || status == "null" {
+		return v, false
+	}
+
+	if json.Unmarshal(bytes, &v) != nil {
+		return v, false
+	}
+
+	return v, true
+}
+
+//==============================================================================================================================
+//	 convert_legacy - Returns the car asset a car record becomes. The numeric status maps onto car_statuses; the
+//					  "UNDEFINED" the car chaincode filled unset fields with and a VIN of 0 become empty.
+//==============================================================================================================================
+func convert_legacy(v5cID string, old LegacyVehicle, caller string) Vehicle {
+
+	defined := func(value string) string {
+		if value == "UNDEFINED" {
+			return ""
+		}
+		return value
+	}
+
+	v := Vehicle{
+		V5cID:           v5cID,
+		AssetId:         v5cID,
+		AssetType:       ASSET_CAR,
+		OwnerId:         old.Owner,
+		Make:            defined(old.Make),
+		Model:           defined(old.Model),
+		Reg:             defined(old.Reg),
+		Colour:          defined(old.Colour),
+		LeaseContractID: old.LeaseContractID,
+		Scrapped:        old.Scrapped,
+		Status:          CAR_TEMPLATE,
+		Caller:          caller,
+	}
+
+	if old.VIN != 0 {
+		v.VIN = strconv.Itoa(old.VIN)
+	}
+
+	if old.Status >= 0 && old.Status < len(car_statuses) {
+		v.Status = car_statuses[old.Status]
+	}
+
+	if old.Scrapped {
+		v.Status = CAR_SCRAPPED
+	}
+
+	return v
+}
+
+//==============================================================================================================================
+//	 retrieve_schema - Reads the schema record. A ledger without one was written before versioning and is taken to be
+//					   at SCHEMA_LEGACY; false is returned with it.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_schema(stub shim.ChaincodeStubInterface) (SchemaState, bool, error) {
+
+	schema := SchemaState{Version: SCHEMA_LEGACY}
+
+	bytes, err := stub.GetState(SCHEMA_KEY)
+
+	if err != nil {
+		return schema, false, errors.New("Unable to get schema record")
+	}
+
+	if bytes == nil {
+		return schema, false, nil
+	}
+
+	err = json.Unmarshal(bytes, &schema)
+
+	if err != nil {
+		return schema, false, errors.New("Corrupt schema record")
+	}
+
+	return schema, true, nil
+}
+
+//==============================================================================================================================
+//	 save_schema - Writes the schema record.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_schema(stub shim.ChaincodeStubInterface, schema SchemaState) error {
+
+	bytes, err := json.Marshal(schema)
+
+	if err != nil {
+		return errors.New("Error converting schema record")
+	}
+
+	err = stub.PutState(SCHEMA_KEY, bytes)
+
+	if err != nil {
+		return errors.New("Error storing schema record")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 init_schema - Called by Init. A fresh ledger is stamped with SCHEMA_VERSION. A ledger holding data but no schema
+//				   record is stamped SCHEMA_LEGACY and left for migrate; nothing on it is rewritten. A ledger written by
+//				   a newer chaincode is refused.
+//==============================================================================================================================
+func (t *SimpleChaincode) init_schema(stub shim.ChaincodeStubInterface, configured bool) error {
+
+	schema, exists, err := t.retrieve_schema(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if schema.Version > SCHEMA_VERSION {
+		return new_error(ERR_INVALID_STATE, fmt.Sprintf("The ledger is at schema version %v, this chaincode reads up to version %v", schema.Version, SCHEMA_VERSION))
+	}
+
+	if exists {
+		return nil
+	}
+
+	legacy, err := t.retrieve_legacy_index(stub)
+
+	if err != nil {
+		return err
+	}
+
+	indexed, err := t.has_assets(stub)
+
+	if err != nil {
+		return err
+	}
+
+	if !configured && len(legacy.V5Cs) == 0 && !indexed {
+		schema.Version = SCHEMA_VERSION
+	}
+
+	return t.save_schema(stub, schema)
+}
+
+//==============================================================================================================================
+//	 convert_asset - Converts the record of the asset passed if it is a car record of the car chaincode. Returns true
+//					 if it was converted.
+//==============================================================================================================================
+func (t *SimpleChaincode) convert_asset(stub shim.ChaincodeStubInterface, v5cID string, caller string) (bool, error) {
+
+	bytes, err := stub.GetState(v5cID)
+
+	if err != nil {
+		return false, errors.New("Unable to get asset " + v5cID)
+	}
+
+	if bytes == nil {
+		return false, nil
+	}
+
+	var before Vehicle
+
+	old, ok := legacy_vehicle(bytes)
+
+	if !ok {
+
+		if json.Unmarshal(bytes, &before) != nil {
+			return false, new_error(ERR_INVALID_STATE, "Asset "+v5cID+" is neither a car record nor an asset").on_asset(v5cID)
+		}
+
+		return false, nil
+	}
+
+	v := convert_legacy(v5cID, old, caller)
+
+	err = t.update_lookups(stub, before, v)
+
+	if err != nil {
+		return false, err
+	}
+
+	bytes, err = json.Marshal(v)
+
+	if err != nil {
+		return false, errors.New("Error converting vehicle record")
+	}
+
+	err = stub.PutState(v5cID, bytes)
+
+	if err != nil {
+		return false, errors.New("Error storing vehicle record")
+	}
+
+	err = t.append_history(stub, v5cID, field_changes(before, v), caller, "migrate", "")
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//=================================================================================================================================
+//	 migrate - Brings the ledger to SCHEMA_VERSION a page at a time. The IDs of the legacy v5cIDs array are moved to
+//			   index keys first, then every asset is checked in ID order and car records are converted. Progress is
+//			   kept in the schema record, so the regulator calls migrate until done is true; once it is, the schema
+//			   version is raised. A page counts IDs moved and assets checked.
+//=================================================================================================================================
+func (t *SimpleChaincode) migrate(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, page PageRequest) ([]byte, error) {
+
+	if caller_affiliation != REGULATOR {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. migrate. Only a REGULATOR may migrate the ledger")
+	}
+
+	schema, _, err := t.retrieve_schema(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := MigrationReport{TargetVersion: SCHEMA_VERSION}
+
+	if schema.Version < SCHEMA_VERSION {
+
+		budget := page.page_size()
+		left := 0
+
+		report.Indexed, left, err = t.move_legacy_index(stub, budget)
+
+		if err != nil {
+			return nil, err
+		}
+
+		budget -= report.Indexed
+
+		// The assets past the page are counted, not loaded
+
+		err = scan_entries(stub, ASSET_INDEX_PREFIX, schema.Bookmark, func(e IndexEntry) (bool, error) {
+
+			if e.Key <= schema.Bookmark {
+				return true, nil
+			}
+
+			if left > 0 || budget == 0 {
+				report.Remaining++
+				return true, nil
+			}
+
+			converted, err := t.convert_asset(stub, e.Key, caller)
+
+			if err != nil {
+				return false, err
+			}
+
+			if converted {
+				report.Converted++
+			}
+
+			report.Checked++
+			budget--
+			schema.Bookmark = e.Key
+
+			return true, nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		report.Remaining += left
+		schema.Converted += report.Converted
+
+		if report.Remaining == 0 {
+			schema.Version, schema.Bookmark = SCHEMA_VERSION, ""
+		}
+
+		err = t.save_schema(stub, schema)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report.SchemaVersion, report.TotalConverted = schema.Version, schema.Converted
+	report.Done = schema.Version == SCHEMA_VERSION
+
+	bytes, err := json.Marshal(report)
+
+	if err != nil {
+		return nil, errors.New("MIGRATE: Error converting report")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	AssetField - Binds the JSON name of a Vehicle field to the field itself so it can be compared and set by name.
+//==============================================================================================================================
+type AssetField struct {
+	Name  string
+	Value func(v *Vehicle) *string
+}
+
+//==============================================================================================================================
+//	FieldPermission - One row of the permission matrix. Roles may write Field while the asset is in one of Stages
+//					  (lifecycle statuses, empty means any status). If Owner is set the asset's current owner may write
+//					  it as well; if OwnerOnly is set only an owner holding one of Roles may. A field marked Once can
+//					  not be changed after it is first written.
+//==============================================================================================================================
+type FieldPermission struct {
+	Field     string
+	Roles     []string
+	Stages    []string
+	Owner     bool
+	OwnerOnly bool
+	Once      bool
+}
+
+//==============================================================================================================================
+//	 asset_fields - The writable data fields of a Vehicle, of trucks and cars alike.
+//==============================================================================================================================
+var asset_fields = []AssetField{
+	{"assetType", func(v *Vehicle) *string { return &v.AssetType }},
+	{"transactionType", func(v *Vehicle) *string { return &v.TransactionType }},
+	{"ownerId", func(v *Vehicle) *string { return &v.OwnerId }},
+	{"matnrAf", func(v *Vehicle) *string { return &v.MatnrAf }},
+	{"poDma", func(v *Vehicle) *string { return &v.PoDma }},
+	{"poSupp", func(v *Vehicle) *string { return &v.PoSupp }},
+	{"dmaDelDate", func(v *Vehicle) *string { return &v.DmaDelDate }},
+	{"afDelDate", func(v *Vehicle) *string { return &v.AfDelDate }},
+	{"truckMod", func(v *Vehicle) *string { return &v.TruckMod }},
+	{"truckPdate", func(v *Vehicle) *string { return &v.TruckPDate }},
+	{"truckChnum", func(v *Vehicle) *string { return &v.TruckChnum }},
+	{"truckEnnum", func(v *Vehicle) *string { return &v.TruckEnnum }},
+	{"suppTest", func(v *Vehicle) *string { return &v.SuppTest }},
+	{"grDma", func(v *Vehicle) *string { return &v.GrDma }},
+	{"grAf", func(v *Vehicle) *string { return &v.GrAf }},
+	{"dmaMasdat", func(v *Vehicle) *string { return &v.DmaMasdat }},
+	{"afDmaTest", func(v *Vehicle) *string { return &v.AfDmaTest }},
+	{"dmaDelCert", func(v *Vehicle) *string { return &v.DmaDelCert }},
+	{"afDoc", func(v *Vehicle) *string { return &v.AfDoc }},
+	{"make", func(v *Vehicle) *string { return &v.Make }},
+	{"model", func(v *Vehicle) *string { return &v.Model }},
+	{"reg", func(v *Vehicle) *string { return &v.Reg }},
+	{"VIN", func(v *Vehicle) *string { return &v.VIN }},
+	{"colour", func(v *Vehicle) *string { return &v.Colour }},
+}
+
+//==============================================================================================================================
+//	 field_permissions - The permission matrix. Each party records its own steps; the regulator may correct the
+//						 order data. Fields not listed here cannot be written through updateAsset, in particular
+//						 ownerId which only changes through offerTransfer/acceptTransfer and afDoc which only shows
+//						 the latest document uploaded through updateDoc/addDoc, and assetType which is fixed at
+//						 creation. The stages of truck and car fields are statuses of their own lifecycle, so neither
+//						 type's fields can be written on the other. The car rows are the rules of the car chaincode's
+//						 update_make, update_vin, update_registration etc., which are now updates of one field.
+//						 The rows of the fields of registered asset types are added by permission_matrix.
+//==============================================================================================================================
+var ordered = []string{STATUS_PO_CREATED, STATUS_IN_PRODUCTION}
+var until_supplier_tested = []string{STATUS_PO_CREATED, STATUS_IN_PRODUCTION, STATUS_SUPPLIER_TESTED}
+var until_dma_tested = []string{STATUS_PO_CREATED, STATUS_IN_PRODUCTION, STATUS_SUPPLIER_TESTED, STATUS_SHIPPED_TO_DMA, STATUS_DMA_RECEIVED, STATUS_DMA_TESTED}
+var until_delivered = []string{STATUS_PO_CREATED, STATUS_IN_PRODUCTION, STATUS_SUPPLIER_TESTED, STATUS_SHIPPED_TO_DMA, STATUS_DMA_RECEIVED, STATUS_DMA_TESTED, STATUS_DELIVERED_TO_AF}
+var until_scrapped = []string{CAR_TEMPLATE, CAR_MANUFACTURE, CAR_PRIVATE_OWNERSHIP, CAR_LEASED_OUT, CAR_BEING_SCRAPPED}
+
+var field_permissions = []FieldPermission{
+	{Field: "transactionType", Roles: []string{REGULATOR}, Owner: true},
+	{Field: "matnrAf", Roles: []string{AF, REGULATOR}, Stages: until_delivered},
+	{Field: "poDma", Roles: []string{DMA, REGULATOR}, Stages: ordered},
+	{Field: "poSupp", Roles: []string{SUPPLIER, REGULATOR}, Stages: ordered},
+	{Field: "truckMod", Roles: []string{SUPPLIER}, Stages: []string{STATUS_IN_PRODUCTION}},
+	{Field: "truckPdate", Roles: []string{SUPPLIER}, Stages: []string{STATUS_IN_PRODUCTION}},
+	{Field: "truckChnum", Roles: []string{SUPPLIER}, Stages: []string{STATUS_IN_PRODUCTION}},
+	{Field: "truckEnnum", Roles: []string{SUPPLIER}, Stages: []string{STATUS_IN_PRODUCTION}},
+	{Field: "suppTest", Roles: []string{SUPPLIER}, Stages: []string{STATUS_IN_PRODUCTION}},
+	{Field: "dmaDelDate", Roles: []string{DMA, SUPPLIER, TRANSPORTER}, Stages: until_supplier_tested},
+	{Field: "grDma", Roles: []string{DMA}, Stages: []string{STATUS_SHIPPED_TO_DMA}},
+	{Field: "dmaMasdat", Roles: []string{DMA}, Stages: []string{STATUS_DMA_RECEIVED}},
+	{Field: "afDmaTest", Roles: []string{DMA}, Stages: []string{STATUS_DMA_RECEIVED}},
+	{Field: "dmaDelCert", Roles: []string{DMA}, Stages: []string{STATUS_DMA_TESTED}},
+	{Field: "afDelDate", Roles: []string{AF, DMA, TRANSPORTER}, Stages: until_dma_tested},
+	{Field: "grAf", Roles: []string{AF}, Stages: []string{STATUS_DELIVERED_TO_AF}},
+	{Field: "make", Roles: []string{MANUFACTURER}, Stages: []string{CAR_MANUFACTURE}, OwnerOnly: true},
+	{Field: "model", Roles: []string{MANUFACTURER}, Stages: []string{CAR_MANUFACTURE}, OwnerOnly: true},
+	{Field: "VIN", Roles: []string{MANUFACTURER}, Stages: []string{CAR_MANUFACTURE}, OwnerOnly: true, Once: true},
+	{Field: "colour", Roles: []string{MANUFACTURER}, Stages: until_scrapped, OwnerOnly: true},
+	{Field: "reg", Roles: []string{REGULATOR, MANUFACTURER, PRIVATE_ENTITY, LEASE_COMPANY}, Stages: until_scrapped, OwnerOnly: true},
+}
+
+//==============================================================================================================================
+//	 as_vehicle - Copies the fields of the incoming request onto a Vehicle so both can be compared field by field.
+//==============================================================================================================================
+func (a Animal) as_vehicle() Vehicle {
+
+	return Vehicle{
+		TransactionType: a.TransactionType,
+		OwnerId:         a.OwnerId,
+		AssetId:         a.AssetId,
+		MatnrAf:         a.MatnrAf,
+		PoDma:           a.PoDma,
+		PoSupp:          a.PoSupp,
+		DmaDelDate:      a.DmaDelDate,
+		AfDelDate:       a.AfDelDate,
+		TruckMod:        a.TruckMod,
+		TruckPDate:      a.TruckPDate,
+		TruckChnum:      a.TruckChnum,
+		TruckEnnum:      a.TruckEnnum,
+		SuppTest:        a.SuppTest,
+		GrDma:           a.GrDma,
+		GrAf:            a.GrAf,
+		DmaMasdat:       a.DmaMasdat,
+		AfDmaTest:       a.AfDmaTest,
+		DmaDelCert:      a.DmaDelCert,
+		AfDoc:           a.AfDoc,
+		Caller:          a.Caller,
+		V5cID:           a.V5cid,
+		AssetType:       a.AssetType,
+		Make:            a.Make,
+		Model:           a.Model,
+		Reg:             a.Reg,
+		VIN:             a.VIN,
+		Colour:          a.Colour,
+	}
+}
+
+//==============================================================================================================================
+//	 changed_fields - Returns the names of the fields update sets to a value different from the one held by v.
+//					  Empty fields in update mean "leave unchanged", as in updateAsset.
+//==============================================================================================================================
+func changed_fields(v Vehicle, update Vehicle) []string {
+
+	changed := []string{}
+
+	for _, f := range asset_fields {
+
+		value := *f.Value(&update)
+
+		if value != "" && value != *f.Value(&v) {
+			changed = append(changed, f.Name)
+		}
+	}
+
+	for _, name := range sorted_keys(update.Attributes) {
+
+		value := update.Attributes[name]
+
+		if value != "" && value != v.Attributes[name] {
+			changed = append(changed, ATTRIBUTE_PREFIX+name)
+		}
+	}
+
+	return changed
+}
+
+//==============================================================================================================================
+//	 apply_fields - Sets the fields named, see changed_fields, of v to their values in update.
+//==============================================================================================================================
+func apply_fields(v *Vehicle, update Vehicle, names []string) {
+
+	attributes := map[string]string{}
+
+	for name, value := range v.Attributes {
+		attributes[name] = value
+	}
+
+	for _, name := range names {
+
+		if strings.HasPrefix(name, ATTRIBUTE_PREFIX) {
+			name = strings.TrimPrefix(name, ATTRIBUTE_PREFIX)
+			attributes[name] = update.Attributes[name]
+			continue
+		}
+
+		f, _ := find_field(name)
+		*f.Value(v) = *f.Value(&update)
+	}
+
+	if len(attributes) > 0 {
+		v.Attributes = attributes
+	}
+}
+
+//==============================================================================================================================
+//	 may_write - Returns true if the caller, acting as role, may write field on v in its current status. matrix is the
+//				 permission matrix of v's type, see AssetTypeDefinition.permission_matrix.
+//==============================================================================================================================
+func may_write(field string, v Vehicle, caller string, role string, matrix []FieldPermission) bool {
+
+	for _, p := range matrix {
+
+		if p.Field != field {
+			continue
+		}
+
+		if len(p.Stages) > 0 && !contains(p.Stages, asset_status(v)) {
+			return false
+		}
+
+		if p.Once && field_value(v, field) != "" {
+			return false
+		}
+
+		if p.OwnerOnly {
+			return contains(p.Roles, role) && v.OwnerId == caller
+		}
+
+		return contains(p.Roles, role) || (p.Owner && v.OwnerId == caller)
+	}
+
+	return false
+}
+
+//==============================================================================================================================
+//	 forbidden_fields - Returns, sorted, the fields in fields the caller may not write on v.
+//==============================================================================================================================
+func forbidden_fields(fields []string, v Vehicle, caller string, role string, matrix []FieldPermission) []string {
+
+	forbidden := []string{}
+
+	for _, field := range fields {
+		if !may_write(field, v, caller, role, matrix) {
+			forbidden = append(forbidden, field)
+		}
+	}
+
+	sort.Strings(forbidden)
+
+	return forbidden
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestMayWrite(t *testing.T) {
+
+	matrix := []FieldPermission{
+		{Field: "poDma", Roles: []string{DMA, REGULATOR}, Stages: ordered},
+		{Field: "transactionType", Roles: []string{REGULATOR}, Owner: true},
+		{Field: "VIN", Roles: []string{MANUFACTURER}, Stages: []string{CAR_MANUFACTURE}, OwnerOnly: true, Once: true},
+	}
+
+	ordered_truck := Vehicle{OwnerId: "supplier1", Status: STATUS_PO_CREATED}
+	received_truck := Vehicle{OwnerId: "dma1", Status: STATUS_DMA_RECEIVED}
+	new_car := Vehicle{AssetType: ASSET_CAR, OwnerId: "maker1", Status: CAR_MANUFACTURE}
+	numbered_car := Vehicle{AssetType: ASSET_CAR, OwnerId: "maker1", Status: CAR_MANUFACTURE, VIN: "123456789012345"}
+	sold_car := Vehicle{AssetType: ASSET_CAR, OwnerId: "maker1", Status: CAR_PRIVATE_OWNERSHIP}
+
+	cases := []struct {
+		name   string
+		field  string
+		v      Vehicle
+		caller string
+		role   string
+		want   bool
+	}{
+		{"role in a listed stage", "poDma", ordered_truck, "dma1", DMA, true},
+		{"role past the stages", "poDma", received_truck, "dma1", DMA, false},
+		{"role not listed", "poDma", ordered_truck, "supplier1", SUPPLIER, false},
+		{"owner of a field open to the owner", "transactionType", ordered_truck, "supplier1", SUPPLIER, true},
+		{"other party of a field open to the owner", "transactionType", ordered_truck, "dma1", DMA, false},
+		{"role of a field open to the owner", "transactionType", ordered_truck, "regulator", REGULATOR, true},
+		{"owner holding the role", "VIN", new_car, "maker1", MANUFACTURER, true},
+		{"role without owning the asset", "VIN", new_car, "maker2", MANUFACTURER, false},
+		{"owner-only field and another role", "VIN", new_car, "regulator", REGULATOR, false},
+		{"once field already written", "VIN", numbered_car, "maker1", MANUFACTURER, false},
+		{"owner past the stages", "VIN", sold_car, "maker1", MANUFACTURER, false},
+		{"field not in the matrix", "grAf", ordered_truck, "regulator", REGULATOR, false},
+	}
+
+	for _, c := range cases {
+		if got := may_write(c.field, c.v, c.caller, c.role, matrix); got != c.want {
+			t.Errorf("%v: may_write(%v) by %v/%v = %v, want %v", c.name, c.field, c.caller, c.role, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Function types - Every chaincode function is either an invoke (may write to the ledger) or a query (read only)
+//==============================================================================================================================
+const INVOKE = "invoke"
+const QUERY = "query"
+
+//==============================================================================================================================
+//	 ALL_PARTICIPANTS - The participant roles of the truck supply chain and the car lifecycle. Used as the role list of
+//						functions any participant may call; the function itself then decides what the caller may see
+//						or change.
+//==============================================================================================================================
+var ALL_PARTICIPANTS = []string{REGULATOR, AF, DMA, SUPPLIER, TRANSPORTER, MANUFACTURER, PRIVATE_ENTITY, LEASE_COMPANY, SCRAP_MERCHANT}
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	PayloadField - Describes one field a function expects in its JSON argument. Name is the path of the field,
+//				   e.g. "asset.assetID". String fields carry the length limit and format of their FieldRule. Array
+//				   fields hold at most MaxLength objects, each described by Items with paths relative to the object.
+//==============================================================================================================================
+type PayloadField struct {
+	Name      string         `json:"name"`
+	Type      string         `json:"type"`
+	Required  bool           `json:"required"`
+	MaxLength int            `json:"maxLength,omitempty"`
+	Pattern   string         `json:"pattern,omitempty"`
+	Items     []PayloadField `json:"items,omitempty"`
+}
+
+//==============================================================================================================================
+//	Request - The parsed form of an incoming call. Built once by the router and handed to the function's handler.
+//==============================================================================================================================
+type Request struct {
+	Function    string
+	Caller      string
+	Affiliation string
+	Asset       Animal
+	Input       InRequest
+}
+
+//==============================================================================================================================
+//	FunctionHandler - Signature shared by every function registered with the router.
+//==============================================================================================================================
+type FunctionHandler func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error)
+
+//==============================================================================================================================
+//	ChaincodeFunction - One entry of the function registry. Name and Type select the entry, Payload describes the
+//						expected input, Roles lists the participants allowed to call it (empty means anyone). Functions
+//						of a Feature can only be called while the feature is on, see Settings.Features.
+//==============================================================================================================================
+type ChaincodeFunction struct {
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	Payload []PayloadField  `json:"payload"`
+	Roles   []string        `json:"roles"`
+	Feature string          `json:"feature,omitempty"`
+	Handler FunctionHandler `json:"-"`
+}
+
+//==============================================================================================================================
+//	 Payload shapes shared between functions
+//==============================================================================================================================
+var no_payload = []PayloadField{}
+
+//==============================================================================================================================
+//	 asset_field_names - The fields of the "asset" object, in the order of the Vehicle struct.
+//==============================================================================================================================
+var asset_field_names = []string{"assetID", "caller", "transactionType", "ownerId", "matnrAf", "poDma", "poSupp",
+	"dmaDelDate", "afDelDate", "truckMod", "truckPdate", "truckChnum", "truckEnnum", "suppTest", "grDma", "grAf",
+	"dmaMasdat", "afDmaTest", "dmaDelCert", "afDoc", "assetType", "make", "model", "reg", "VIN", "colour"}
+
+//==============================================================================================================================
+//	 external_field_names - The fields of the "asset" object an external update may set: all but the asset ID, which
+//							is looked up from the business key, and the caller.
+//==============================================================================================================================
+var external_field_names = asset_field_names[2:]
+
+//==============================================================================================================================
+//	 field_path - Returns the path of the field name of object. Fields of the items of an array pass an empty object
+//				  for their own top level.
+//==============================================================================================================================
+func field_path(object string, name string) string {
+
+	if object == "" {
+		return name
+	}
+
+	return object + "." + name
+}
+
+//==============================================================================================================================
+//	 fields_payload - Builds a payload description from the names of fields of object, marking those listed in required.
+//					  Each field follows the rule of its name, see field_rules.
+//==============================================================================================================================
+func fields_payload(object string, names []string, required ...string) []PayloadField {
+
+	payload := []PayloadField{}
+
+	for _, name := range names {
+
+		rule := field_rule(name)
+
+		payload = append(payload, PayloadField{Name: field_path(object, name), Type: "string", Required: contains(required, name), MaxLength: rule.MaxLength, Pattern: rule.Pattern})
+	}
+
+	return payload
+}
+
+//==============================================================================================================================
+//	 numbers_payload - Builds a payload description of whole number fields of object.
+//==============================================================================================================================
+func numbers_payload(object string, names ...string) []PayloadField {
+
+	payload := []PayloadField{}
+
+	for _, name := range names {
+		payload = append(payload, PayloadField{Name: field_path(object, name), Type: "number"})
+	}
+
+	return payload
+}
+
+//==============================================================================================================================
+//	 flags_payload - Builds a payload description of true/false fields of object.
+//==============================================================================================================================
+func flags_payload(object string, names ...string) []PayloadField {
+
+	payload := []PayloadField{}
+
+	for _, name := range names {
+		payload = append(payload, PayloadField{Name: field_path(object, name), Type: "boolean"})
+	}
+
+	return payload
+}
+
+//==============================================================================================================================
+//	 typed_payload - Builds a payload description of fields of object holding values of the type passed, e.g. "object"
+//					 for objects whose keys are checked by the function itself.
+//==============================================================================================================================
+func typed_payload(object string, field_type string, names ...string) []PayloadField {
+
+	payload := []PayloadField{}
+
+	for _, name := range names {
+
+		rule := field_rule(name)
+
+		if field_type != "string[]" {
+			rule = FieldRule{}
+		}
+
+		payload = append(payload, PayloadField{Name: field_path(object, name), Type: field_type, MaxLength: rule.MaxLength, Pattern: rule.Pattern})
+	}
+
+	return payload
+}
+
+//==============================================================================================================================
+//	 array_payload - Builds the payload description of an array of up to max_items objects, each described by items.
+//==============================================================================================================================
+func array_payload(name string, items []PayloadField, max_items int) []PayloadField {
+
+	return []PayloadField{{Name: name, Type: "array", Required: true, MaxLength: max_items, Items: items}}
+}
+
+//==============================================================================================================================
+//	 join_payloads - Concatenates payload descriptions.
+//==============================================================================================================================
+func join_payloads(payloads ...[]PayloadField) []PayloadField {
+
+	joined := []PayloadField{}
+
+	for _, p := range payloads {
+		joined = append(joined, p...)
+	}
+
+	return joined
+}
+
+//==============================================================================================================================
+//	 car_field_payload - Builds the payload of an update function of the car chaincode, e.g. update_make: the asset and
+//						 the one field it writes.
+//==============================================================================================================================
+func car_field_payload(name string) []PayloadField {
+
+	return fields_payload("asset", []string{"assetID", "caller", name}, "assetID", name)
+}
+
+var attributes_payload = typed_payload("asset", "object", "attributes")
+var create_payload = join_payloads(fields_payload("asset", asset_field_names), attributes_payload)
+var update_payload = join_payloads(fields_payload("asset", asset_field_names, "assetID"), attributes_payload)
+var create_assets_payload = array_payload("assets", create_payload, MAX_BATCH_SIZE)
+var external_update_payload = join_payloads(fields_payload("", []string{"messageId", "keyField", "keyValue"}, "messageId", "keyField", "keyValue"),
+	flags_payload("", "applyToAll"), fields_payload("asset", external_field_names))
+var external_updates_payload = array_payload("updates", external_update_payload, MAX_BATCH_SIZE)
+var asset_key_payload = fields_payload("asset", []string{"assetID", "caller"}, "assetID")
+var options_payload = fields_payload("options", []string{"format"})
+var read_payload = join_payloads(asset_key_payload, options_payload)
+var doc_payload = fields_payload("asset", []string{"assetID", "caller", "afDoc"}, "assetID", "afDoc")
+var add_doc_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"docType", "fileName", "mimeType", "content"}, "docType", "content"))
+var read_doc_payload = join_payloads(read_payload, fields_payload("doc", []string{"docId"}), numbers_payload("doc", "offset", "length"))
+var begin_upload_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"docType", "fileName", "mimeType", "sha256"}, "docType"))
+var chunk_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"uploadId", "content"}, "uploadId", "content"))
+var commit_upload_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"uploadId", "sha256"}, "uploadId"))
+var upload_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"uploadId"}, "uploadId"))
+var anchor_doc_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"docId", "docType", "fileName", "mimeType", "sha256", "uri"}, "sha256", "uri"), numbers_payload("doc", "size"))
+var verify_doc_payload = join_payloads(asset_key_payload, fields_payload("doc", []string{"docId", "sha256"}, "sha256"))
+var transition_payload = join_payloads(fields_payload("asset", append([]string{"status"}, asset_field_names...), "assetID", "status"), attributes_payload)
+var offer_payload = join_payloads(asset_key_payload, fields_payload("transfer", []string{"recipient", "expiresAt"}, "recipient"))
+var car_move_payload = join_payloads(asset_key_payload, fields_payload("transfer", []string{"recipient", "recipientRole"}, "recipient", "recipientRole"))
+var caller_payload = fields_payload("asset", []string{"caller"})
+var asset_id_payload = fields_payload("asset", []string{"assetID"}, "assetID")
+var v5c_id_payload = with_rule(asset_id_payload, "asset.assetID", FieldRule{MaxLength: 9, Pattern: PATTERN_V5C_ID})
+var key_payload = with_rule(asset_id_payload, "asset.assetID", FieldRule{MaxLength: 64, Pattern: PATTERN_IDENTITY})
+var po_payload = join_payloads(fields_payload("asset", []string{"caller", "poDma", "poSupp"}), options_payload)
+var chassis_payload = join_payloads(fields_payload("asset", []string{"caller", "truckChnum"}, "truckChnum"), options_payload)
+var engine_payload = join_payloads(fields_payload("asset", []string{"caller", "truckEnnum"}, "truckEnnum"), options_payload)
+var material_payload = join_payloads(fields_payload("asset", []string{"caller", "matnrAf"}, "matnrAf"), options_payload)
+var page_payload = join_payloads(numbers_payload("page", "size"), fields_payload("page", []string{"bookmark"}))
+var config_payload = join_payloads(fields_payload("config", []string{"identityMode", "assetIdMode", "assetIdPrefix", "assetIdCheckDigit"}),
+	numbers_payload("config", "dateHorizonDays", "assetIdWidth", "maxDocSize", "maxBatchSize", "defaultPageSize", "maxPageSize"),
+	typed_payload("config", "object", "idPatterns", "roles", "features"), typed_payload("config", "string[]", "admins"))
+var field_name_rule = FieldRule{MaxLength: 32, Pattern: PATTERN_FIELD_NAME}
+var field_definition_payload = with_rule(join_payloads(fields_payload("", []string{"name", "type", "pattern"}, "name", "type"), numbers_payload("", "maxLength"),
+	flags_payload("", "required", "owner", "ownerOnly", "once"), typed_payload("", "string[]", "roles", "stages")), "name", field_name_rule)
+var transition_definition_payload = with_rule(join_payloads(fields_payload("", []string{"from", "to"}, "from", "to"), typed_payload("", "string[]", "roles", "required")), "required", field_name_rule)
+var asset_type_payload = join_payloads(with_rule(fields_payload("definition", []string{"name"}, "name"), "definition.name", field_rule("assetType")),
+	typed_payload("definition", "string[]", "statuses"), []PayloadField{
+		{Name: "definition.fields", Type: "array", MaxLength: MAX_TYPE_FIELDS, Items: field_definition_payload},
+		{Name: "definition.transitions", Type: "array", MaxLength: MAX_TYPE_TRANSITIONS, Items: transition_definition_payload}})
+var history_payload = join_payloads(asset_key_payload, page_payload)
+var list_payload = join_payloads(caller_payload, page_payload, fields_payload("page", []string{"sortBy"}), options_payload,
+	fields_payload("filter", []string{"assetType", "ownerId", "truckMod", "matnrAf", "transactionType", "dmaDelDateFrom", "dmaDelDateTo", "afDelDateFrom", "afDelDateTo"}))
+
+//==============================================================================================================================
+//	 functions - The function registry. Invoke and Query only accept calls to functions listed here.
+//==============================================================================================================================
+var functions []ChaincodeFunction
+
+func init() {
+
+	functions = []ChaincodeFunction{
+		{Name: "create_vehicle", Type: INVOKE, Payload: v5c_id_payload, Roles: []string{REGULATOR}, Feature: FEATURE_LEGACY_FUNCTIONS, Handler: invoke_create_vehicle},
+		{Name: "createAsset", Type: INVOKE, Payload: create_payload, Roles: []string{REGULATOR}, Handler: invoke_create_asset},
+		{Name: "createAssets", Type: INVOKE, Payload: create_assets_payload, Roles: []string{REGULATOR}, Feature: FEATURE_BATCH_CREATE, Handler: invoke_create_assets},
+		{Name: "updateAsset", Type: INVOKE, Payload: update_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_asset)},
+		{Name: "applyExternalUpdates", Type: INVOKE, Payload: external_updates_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_EXTERNAL_UPDATES, Handler: invoke_apply_external_updates},
+		{Name: "updateDoc", Type: INVOKE, Payload: doc_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_doc)},
+		{Name: "addDoc", Type: INVOKE, Payload: add_doc_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_add_doc)},
+		{Name: "beginUpload", Type: INVOKE, Payload: begin_upload_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_UPLOADS, Handler: with_asset(invoke_begin_upload)},
+		{Name: "appendChunk", Type: INVOKE, Payload: chunk_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_UPLOADS, Handler: with_asset(invoke_append_chunk)},
+		{Name: "commitUpload", Type: INVOKE, Payload: commit_upload_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_UPLOADS, Handler: with_asset(invoke_commit_upload)},
+		{Name: "abortUpload", Type: INVOKE, Payload: upload_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_UPLOADS, Handler: with_asset(invoke_abort_upload)},
+		{Name: "anchorDoc", Type: INVOKE, Payload: anchor_doc_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_anchor_doc)},
+		{Name: "transitionAsset", Type: INVOKE, Payload: transition_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_transition_asset)},
+		{Name: "authority_to_manufacturer", Type: INVOKE, Payload: car_move_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "manufacturer_to_private", Type: INVOKE, Payload: car_move_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "private_to_private", Type: INVOKE, Payload: car_move_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "private_to_lease_company", Type: INVOKE, Payload: car_move_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "lease_company_to_private", Type: INVOKE, Payload: car_move_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "private_to_scrap_merchant", Type: INVOKE, Payload: car_move_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "scrap_vehicle", Type: INVOKE, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_move_car)},
+		{Name: "update_vin", Type: INVOKE, Payload: car_field_payload("VIN"), Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_car)},
+		{Name: "update_registration", Type: INVOKE, Payload: car_field_payload("reg"), Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_car)},
+		{Name: "update_colour", Type: INVOKE, Payload: car_field_payload("colour"), Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_car)},
+		{Name: "update_make", Type: INVOKE, Payload: car_field_payload("make"), Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_car)},
+		{Name: "update_model", Type: INVOKE, Payload: car_field_payload("model"), Roles: ALL_PARTICIPANTS, Handler: with_asset(invoke_update_car)},
+		{Name: "offerTransfer", Type: INVOKE, Payload: offer_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_TRANSFERS, Handler: with_asset(invoke_offer_transfer)},
+		{Name: "acceptTransfer", Type: INVOKE, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_TRANSFERS, Handler: with_asset(invoke_accept_transfer)},
+		{Name: "rejectTransfer", Type: INVOKE, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_TRANSFERS, Handler: with_asset(invoke_reject_transfer)},
+		{Name: "cancelTransfer", Type: INVOKE, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_TRANSFERS, Handler: with_asset(invoke_cancel_transfer)},
+		{Name: "reindexAssets", Type: INVOKE, Payload: page_payload, Roles: []string{REGULATOR}, Handler: invoke_reindex_assets},
+		{Name: "migrateAssetIndex", Type: INVOKE, Payload: page_payload, Roles: []string{REGULATOR}, Handler: invoke_migrate_asset_index},
+		{Name: "migrate", Type: INVOKE, Payload: page_payload, Roles: []string{REGULATOR}, Handler: invoke_migrate},
+		{Name: "updateConfig", Type: INVOKE, Payload: config_payload, Roles: []string{REGULATOR}, Handler: invoke_update_config},
+		{Name: "registerAssetType", Type: INVOKE, Payload: asset_type_payload, Roles: []string{REGULATOR}, Handler: invoke_register_asset_type},
+		{Name: "ping", Type: INVOKE, Payload: no_payload, Handler: call_ping},
+
+		{Name: "readAsset", Type: QUERY, Payload: read_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_read_asset)},
+		{Name: "get_vehicle_details", Type: QUERY, Payload: read_payload, Roles: ALL_PARTICIPANTS, Feature: FEATURE_LEGACY_FUNCTIONS, Handler: with_asset(query_read_asset)},
+		{Name: "readAllAssets", Type: QUERY, Payload: list_payload, Roles: ALL_PARTICIPANTS, Handler: query_read_all_assets},
+		{Name: "get_vehicles", Type: QUERY, Payload: caller_payload, Roles: []string{REGULATOR}, Feature: FEATURE_LEGACY_FUNCTIONS, Handler: query_get_vehicles},
+		{Name: "readAssetByPO", Type: QUERY, Payload: po_payload, Roles: ALL_PARTICIPANTS, Handler: query_read_asset_by_po},
+		{Name: "readAssetByChassis", Type: QUERY, Payload: chassis_payload, Roles: ALL_PARTICIPANTS, Handler: query_read_asset_by_chassis},
+		{Name: "readAssetByEngine", Type: QUERY, Payload: engine_payload, Roles: ALL_PARTICIPANTS, Handler: query_read_asset_by_engine},
+		{Name: "readAssetByMaterial", Type: QUERY, Payload: material_payload, Roles: ALL_PARTICIPANTS, Handler: query_read_asset_by_material},
+		{Name: "readAllowedTransitions", Type: QUERY, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_read_allowed_transitions)},
+		{Name: "readAssetHistory", Type: QUERY, Payload: history_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_read_asset_history)},
+		{Name: "readTransfer", Type: QUERY, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_read_transfer)},
+		{Name: "listDocs", Type: QUERY, Payload: asset_key_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_list_docs)},
+		{Name: "readDoc", Type: QUERY, Payload: read_doc_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_read_doc)},
+		{Name: "verifyDoc", Type: QUERY, Payload: verify_doc_payload, Roles: ALL_PARTICIPANTS, Handler: with_asset(query_verify_doc)},
+		{Name: "check_unique_v5c", Type: QUERY, Payload: key_payload, Handler: query_check_unique},
+		{Name: "get_ecert", Type: QUERY, Payload: key_payload, Roles: []string{REGULATOR}, Handler: query_get_ecert},
+		{Name: "ping", Type: QUERY, Payload: no_payload, Handler: call_ping},
+		{Name: "readConfig", Type: QUERY, Payload: page_payload, Roles: []string{REGULATOR}, Handler: query_read_config},
+		{Name: "readAssetTypes", Type: QUERY, Payload: caller_payload, Roles: ALL_PARTICIPANTS, Handler: query_read_asset_types},
+		{Name: "listFunctions", Type: QUERY, Payload: no_payload, Handler: query_list_functions},
+		{Name: "listErrorCodes", Type: QUERY, Payload: no_payload, Handler: query_list_error_codes},
+	}
+
+	compile_patterns(functions)
+}
+
+//==============================================================================================================================
+//	 find_function - Looks up the registry entry for the function name and type passed.
+//==============================================================================================================================
+func find_function(name string, function_type string) (ChaincodeFunction, bool) {
+
+	for _, f := range functions {
+		if f.Name == name && f.Type == function_type {
+			return f, true
+		}
+	}
+
+	return ChaincodeFunction{}, false
+}
+
+//==============================================================================================================================
+//	 find_payload_field - Looks up the field at path in the payload description passed.
+//==============================================================================================================================
+func find_payload_field(payload []PayloadField, path string) (PayloadField, bool) {
+
+	for _, field := range payload {
+		if field.Name == path {
+			return field, true
+		}
+	}
+
+	return PayloadField{}, false
+}
+
+//==============================================================================================================================
+//	 has_role - Returns true if role is one of roles, or if roles is empty.
+//==============================================================================================================================
+func has_role(roles []string, role string) bool {
+
+	return len(roles) == 0 || contains(roles, role)
+}
+
+//==============================================================================================================================
+//	 contains - Returns true if value is one of list.
+//==============================================================================================================================
+func contains(list []string, value string) bool {
+
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+//==============================================================================================================================
+//	 parse_request - Checks the JSON argument of a call against the function's payload schema and unmarshals it.
+//					 A missing argument is treated as an empty object so functions without a payload need no arguments.
+//==============================================================================================================================
+func parse_request(f ChaincodeFunction, args []string) (Request, error) {
+
+	var req Request
+
+	req.Function = f.Name
+
+	payload := "{}"
+
+	if len(args) > 0 && strings.TrimSpace(args[0]) != "" {
+		payload = args[0]
+	}
+
+	err := validate_payload(f, payload)
+
+	if err != nil {
+		return req, err
+	}
+
+	err = json.Unmarshal([]byte(payload), &req.Input)
+
+	if err != nil {
+		return req, new_error(ERR_INVALID_JSON, f.Name+": Invalid JSON argument: "+err.Error())
+	}
+
+	req.Asset = req.Input.Asset
+	req.Asset.normalise_dates()
+
+	// IMPORTANT: v5cid variable is used in most of the places in this contract
+	// the frontend will pass the field assetID as the
+	// copy assetID over to v5cid here
+	req.Asset.V5cid = req.Asset.AssetId
+
+	return req, nil
+}
+
+//==============================================================================================================================
+//	 lookup_path - Returns the value at the dotted path passed, e.g. "asset.assetID", or nil if there is none.
+//==============================================================================================================================
+func lookup_path(raw map[string]interface{}, path string) interface{} {
+
+	var value interface{} = raw
+
+	for _, name := range strings.Split(path, ".") {
+
+		object, ok := value.(map[string]interface{})
+
+		if !ok {
+			return nil
+		}
+
+		value = object[name]
+	}
+
+	return value
+}
+
+//==============================================================================================================================
+//	 route - Shared body of Invoke and Query. Finds the registry entry, parses the argument, establishes the caller,
+//			 checks the caller's participant role and hands over to the function's handler. Whatever fails, the
+//			 caller gets a ChaincodeError.
+//==============================================================================================================================
+func (t *SimpleChaincode) route(stub shim.ChaincodeStubInterface, function_type string, function string, args []string) (result []byte, err error) {
+
+	var req Request
+
+	defer func() {
+		if err != nil {
+			err = as_chaincode_error(err, req.Asset.V5cid)
+		}
+	}()
+
+	f, ok := find_function(function, function_type)
+
+	if !ok {
+		return nil, new_error(ERR_UNKNOWN_FUNCTION, "Received unknown "+function_type+" function "+function)
+	}
+
+	req, err = parse_request(f, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.feature_enabled(f.Feature) {
+		return nil, new_error(ERR_FEATURE_DISABLED, fmt.Sprintf("%v is switched off, feature %v is disabled by the regulator", f.Name, f.Feature))
+	}
+
+	err = t.establish_caller(stub, settings, &req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Input.Page.Size = settings.page_size(req.Input.Page.Size)
+
+	logger.Debug("function: ", function)
+	logger.Debug("caller: ", req.Caller)
+	logger.Debug("affiliation: ", req.Affiliation)
+
+	if !has_role(f.Roles, req.Affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission Denied. %v may only be called by %v, caller: %v role: %v", f.Name, f.Roles, req.Caller, req.Affiliation))
+	}
+
+	return f.Handler(t, stub, req)
+}
+
+//==============================================================================================================================
+//	 AssetHandler - Signature of handlers that work on the asset named by asset.assetID, see with_asset.
+//==============================================================================================================================
+type AssetHandler func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error)
+
+//==============================================================================================================================
+//	 with_asset - Wraps an AssetHandler into a FunctionHandler that first retrieves the asset named in the request.
+//==============================================================================================================================
+func with_asset(handler AssetHandler) FunctionHandler {
+
+	return func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+
+		v, err := t.retrieve_v5c(stub, req.Asset.V5cid)
+
+		if err != nil {
+			return nil, wrap_error("Error retrieving v5c", err)
+		}
+
+		return handler(t, stub, v, req)
+	}
+}
+
+//==============================================================================================================================
+//	 Handlers - Adapt the registry's Request to the existing chaincode functions.
+//==============================================================================================================================
+func invoke_create_vehicle(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.create_vehicle(stub, req.Caller, req.Affiliation, req.Asset.V5cid)
+}
+
+func invoke_create_asset(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.createAsset(stub, req.Caller, req.Affiliation, req.Asset.V5cid, req.Asset)
+}
+
+func invoke_create_assets(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.createAssets(stub, req.Caller, req.Affiliation, req.Input.Assets)
+}
+
+func invoke_apply_external_updates(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.applyExternalUpdates(stub, req.Caller, req.Affiliation, req.Input.Updates)
+}
+
+func invoke_update_config(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.updateConfig(stub, req.Caller, req.Affiliation, req.Input.Config)
+}
+
+func invoke_register_asset_type(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.registerAssetType(stub, req.Caller, req.Affiliation, req.Input.Definition)
+}
+
+func invoke_update_asset(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.updateAsset(stub, v, req.Caller, req.Affiliation, "dummy new value", req.Asset)
+}
+
+func invoke_update_doc(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.updateDoc(stub, v, req.Caller, req.Affiliation, req.Asset)
+}
+
+func invoke_add_doc(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.addDoc(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func invoke_begin_upload(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.beginUpload(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func invoke_append_chunk(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.appendChunk(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func invoke_commit_upload(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.commitUpload(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func invoke_abort_upload(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.abortUpload(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func invoke_anchor_doc(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.anchorDoc(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func invoke_transition_asset(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.transitionAsset(stub, v, req.Caller, req.Affiliation, req.Asset)
+}
+
+func invoke_offer_transfer(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.offerTransfer(stub, v, req.Caller, req.Affiliation, req.Input.Transfer)
+}
+
+func invoke_accept_transfer(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.acceptTransfer(stub, v, req.Caller, req.Affiliation)
+}
+
+func invoke_reject_transfer(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.rejectTransfer(stub, v, req.Caller, req.Affiliation)
+}
+
+func invoke_cancel_transfer(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.cancelTransfer(stub, v, req.Caller, req.Affiliation)
+}
+
+func invoke_migrate_asset_index(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.migrateAssetIndex(stub, req.Caller, req.Affiliation, req.Input.Page)
+}
+
+func invoke_migrate(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.migrate(stub, req.Caller, req.Affiliation, req.Input.Page)
+}
+
+func invoke_move_car(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.move_car(stub, v, req.Caller, req.Affiliation, req.Function, req.Input.Transfer)
+}
+
+func invoke_update_car(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.update_car(stub, v, req.Caller, req.Affiliation, req.Asset, req.Function)
+}
+
+func invoke_reindex_assets(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.reindexAssets(stub, req.Caller, req.Affiliation, req.Input.Page)
+}
+
+func call_ping(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.ping(stub)
+}
+
+func query_read_asset(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.get_vehicle_details(stub, v, req.Caller, req.Affiliation, req.Input.Options.response_format())
+}
+
+//==============================================================================================================================
+//	 query_read_asset_by_po - A PO number may be passed as poDma or poSupp; it is looked up among both.
+//==============================================================================================================================
+func query_read_asset_by_po(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+
+	po := req.Asset.PoDma
+
+	if po == "" {
+		po = req.Asset.PoSupp
+	}
+
+	return t.readAssetsBy(stub, []string{"poDma", "poSupp"}, po, req.Caller, req.Affiliation, req.Input.Options.response_format())
+}
+
+func query_read_asset_by_chassis(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.readAssetByUniqueKey(stub, "truckChnum", req.Asset.TruckChnum, req.Caller, req.Affiliation, req.Input.Options.response_format())
+}
+
+func query_read_asset_by_engine(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.readAssetByUniqueKey(stub, "truckEnnum", req.Asset.TruckEnnum, req.Caller, req.Affiliation, req.Input.Options.response_format())
+}
+
+func query_read_asset_by_material(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.readAssetsBy(stub, []string{"matnrAf"}, req.Asset.MatnrAf, req.Caller, req.Affiliation, req.Input.Options.response_format())
+}
+
+func query_read_allowed_transitions(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.readAllowedTransitions(stub, v, req.Caller, req.Affiliation)
+}
+
+func query_read_asset_history(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.readAssetHistory(stub, v, req.Caller, req.Affiliation, req.Input.Page)
+}
+
+func query_read_transfer(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.readTransfer(stub, v, req.Caller, req.Affiliation)
+}
+
+func query_read_all_assets(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.readAllAssets(stub, req.Caller, req.Affiliation, req.Input.Filter, req.Input.Page, req.Input.Options.response_format())
+}
+
+func query_get_vehicles(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.get_vehicles(stub, req.Caller, req.Affiliation)
+}
+
+func query_list_docs(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.listDocs(stub, v, req.Caller, req.Affiliation)
+}
+
+//==============================================================================================================================
+//	 query_read_doc - Without a docId readDoc returns the afDoc view as it always did.
+//==============================================================================================================================
+func query_read_doc(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+
+	if req.Input.Doc.DocId != "" {
+		return t.readDocById(stub, v, req.Caller, req.Affiliation, req.Input.Doc.DocId, req.Input.Doc.Offset, req.Input.Doc.Length)
+	}
+
+	return t.readDoc(stub, v, req.Caller, req.Affiliation, req.Input.Options.response_format())
+}
+
+func query_verify_doc(t *SimpleChaincode, stub shim.ChaincodeStubInterface, v Vehicle, req Request) ([]byte, error) {
+	return t.verifyDoc(stub, v, req.Caller, req.Affiliation, req.Input.Doc)
+}
+
+func query_check_unique(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.check_unique_v5c(stub, req.Asset.V5cid, req.Caller, req.Affiliation)
+}
+
+func query_get_ecert(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.get_ecert(stub, req.Asset.V5cid)
+}
+
+//==============================================================================================================================
+//	 query_read_config - The configuration is paged like the history: the page request pages through its changes.
+//==============================================================================================================================
+func query_read_config(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.readConfig(stub, req.Caller, req.Affiliation, req.Input.Page)
+}
+
+func query_read_asset_types(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.readAssetTypes(stub)
+}
+
+//==============================================================================================================================
+//	 query_list_functions - Returns the function registry so clients can discover the API.
+//==============================================================================================================================
+func query_list_functions(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+
+	bytes, err := json.Marshal(functions)
+
+	if err != nil {
+		return nil, errors.New("LISTFUNCTIONS: Error converting function registry")
+	}
+
+	return bytes, nil
+}
+
+func query_list_error_codes(t *SimpleChaincode, stub shim.ChaincodeStubInterface, req Request) ([]byte, error) {
+	return t.listErrorCodes(stub)
+}
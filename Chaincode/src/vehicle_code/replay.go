@@ -0,0 +1,820 @@
+//go:build replay
+// +build replay
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Scenario replay - Built with the replay tag the chaincode binary replays scenario files against shim.MockStub
+//					   instead of connecting to a peer:
+//
+//						go build -tags replay -o replay && ./replay scenarios/supplier_dma_af.jsonl
+//
+//					   A scenario file holds JSON steps, one per line or as an array. Each step calls one chaincode
+//					   function as the caller and role given and may assert on the result, the error code, the event
+//					   sent and the world state after it. Every file is replayed on an empty ledger; unless its first
+//					   step is an init or a seed, Init is called without arguments first. A seed step writes world
+//					   state directly, e.g. records of an earlier chaincode version, without calling the chaincode.
+//==============================================================================================================================
+
+//==============================================================================================================================
+//	 REPLAY_START - The transaction time of the first step unless the scenario sets one. Every invoke advances the
+//					clock by REPLAY_TICK.
+//==============================================================================================================================
+const REPLAY_START = "2017-01-02T09:00:00Z"
+const REPLAY_TICK = time.Minute
+
+//==============================================================================================================================
+//	 Step types
+//==============================================================================================================================
+const STEP_INIT = "init"
+const STEP_INVOKE = "invoke"
+const STEP_QUERY = "query"
+const STEP_SEED = "seed"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	ScenarioStep - One call of a scenario. Type is taken from the function registry when left out. Payload is the JSON
+//				   argument, an object or a string passed as it is; Args are the name/value pairs of an init step.
+//				   Time sets the transaction clock, as RFC3339. State holds the keys a seed step writes: strings
+//				   are stored as they are, other JSON values as JSON text.
+//==============================================================================================================================
+type ScenarioStep struct {
+	Name     string                     `json:"name"`
+	Type     string                     `json:"type"`
+	Function string                     `json:"function"`
+	Payload  json.RawMessage            `json:"payload"`
+	Args     []string                   `json:"args"`
+	Caller   string                     `json:"caller"`
+	Role     string                     `json:"role"`
+	Time     string                     `json:"time"`
+	State    map[string]json.RawMessage `json:"state"`
+	Expect   StepExpectation            `json:"expect"`
+}
+
+//==============================================================================================================================
+//	StepExpectation - What a step asserts. A step is expected to succeed unless Error names the code it must fail
+//					  with. Result, Event and the values of State are matched as subsets: objects need only hold the
+//					  fields given, see json_subset. A State value of null asserts that the key does not exist.
+//==============================================================================================================================
+type StepExpectation struct {
+	Error  string                     `json:"error"`
+	Result json.RawMessage            `json:"result"`
+	Event  json.RawMessage            `json:"event"`
+	State  map[string]json.RawMessage `json:"state"`
+}
+
+//==============================================================================================================================
+//	StepReport - The outcome of one step. Failures lists each assertion that did not hold, Diff the world state
+//				 changes the step made.
+//==============================================================================================================================
+type StepReport struct {
+	Number   int
+	Step     ScenarioStep
+	Result   []byte
+	Err      error
+	Failures []string
+	Diff     []string
+}
+
+//==============================================================================================================================
+//	replay_ledger - The parts of the replay stub the runner drives. Kept apart from replay_stub, which is generic.
+//==============================================================================================================================
+type replay_ledger struct {
+	mock   *shim.MockStub
+	attrs  map[string]string
+	now    time.Time
+	events [][]byte
+	txn    int
+}
+
+//==============================================================================================================================
+//	replay_stub - shim.MockStub with the parts the chaincode needs that MockStub leaves unimplemented: eCert attributes,
+//				  transaction timestamps, events and inclusive, ordered range queries. T is the timestamp type of
+//				  GetTxTimestamp, which lives in the vendor directory of fabric and cannot be named from here.
+//==============================================================================================================================
+type replay_stub[T any] struct {
+	*shim.MockStub
+	*replay_ledger
+}
+
+func (s *replay_stub[T]) ReadCertAttribute(name string) ([]byte, error) {
+
+	return []byte(s.attrs[name]), nil
+}
+
+func (s *replay_stub[T]) GetTxTimestamp() (T, error) {
+
+	var ts T
+
+	v := reflect.New(reflect.TypeOf(ts).Elem())
+	v.Elem().FieldByName("Seconds").SetInt(s.now.Unix())
+	v.Elem().FieldByName("Nanos").SetInt(int64(s.now.Nanosecond()))
+
+	return v.Interface().(T), nil
+}
+
+func (s *replay_stub[T]) SetEvent(name string, payload []byte) error {
+
+	s.events = append(s.events, payload)
+
+	return nil
+}
+
+func (s *replay_stub[T]) RangeQueryState(startKey string, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+
+	keys := []string{}
+
+	for key := range s.State {
+		if key >= startKey && key <= endKey {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return &replay_iterator{state: s.State, keys: keys}, nil
+}
+
+//==============================================================================================================================
+//	replay_iterator - Iterates over a snapshot of the keys in range.
+//==============================================================================================================================
+type replay_iterator struct {
+	state map[string][]byte
+	keys  []string
+	next  int
+}
+
+func (it *replay_iterator) HasNext() bool {
+
+	return it.next < len(it.keys)
+}
+
+func (it *replay_iterator) Next() (string, []byte, error) {
+
+	if !it.HasNext() {
+		return "", nil, errors.New("Range query iterator has no next key")
+	}
+
+	key := it.keys[it.next]
+	it.next++
+
+	return key, it.state[key], nil
+}
+
+func (it *replay_iterator) Close() error {
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 new_replay_stub - Returns an empty ledger. timestamp is only passed to infer the timestamp type of the stub.
+//==============================================================================================================================
+func new_replay_stub[T any](cc *SimpleChaincode, timestamp func() (T, error)) (shim.ChaincodeStubInterface, *replay_ledger) {
+
+	mock := shim.NewMockStub("replay", cc)
+
+	start, _ := time.Parse(time.RFC3339, REPLAY_START)
+
+	ledger := &replay_ledger{mock: mock, attrs: map[string]string{}, now: start}
+
+	//T is only known to be the timestamp type once instantiated, hence the assertion
+	stub := interface{}(&replay_stub[T]{MockStub: mock, replay_ledger: ledger}).(shim.ChaincodeStubInterface)
+
+	return stub, ledger
+}
+
+//==============================================================================================================================
+//	 snapshot - Returns a copy of the world state.
+//==============================================================================================================================
+func (l *replay_ledger) snapshot() map[string][]byte {
+
+	state := map[string][]byte{}
+
+	for key, value := range l.mock.State {
+		state[key] = value
+	}
+
+	return state
+}
+
+//==============================================================================================================================
+//	 restore - Puts the world state back to a snapshot. A peer discards the writes of a failed transaction, MockStub
+//			   keeps them, so failed steps are rolled back here.
+//==============================================================================================================================
+func (l *replay_ledger) restore(state map[string][]byte) {
+
+	keys := []string{}
+
+	l.mock.State = map[string][]byte{}
+
+	for key, value := range state {
+		l.mock.State[key] = value
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	l.mock.Keys = list.New()
+
+	for _, key := range keys {
+		l.mock.Keys.PushBack(key)
+	}
+}
+
+//==============================================================================================================================
+//	 seed - Writes the keys of a seed step to the world state.
+//==============================================================================================================================
+func (l *replay_ledger) seed(state map[string]json.RawMessage) error {
+
+	after := l.snapshot()
+
+	for key, raw := range state {
+
+		value := []byte(bytes.TrimSpace(raw))
+
+		if len(value) > 0 && value[0] == '"' {
+
+			var s string
+
+			if err := json.Unmarshal(value, &s); err != nil {
+				return err
+			}
+
+			value = []byte(s)
+		}
+
+		after[key] = value
+	}
+
+	l.restore(after)
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 read_scenario - Reads the steps of a scenario file: JSON lines, a sequence of objects or arrays of them.
+//==============================================================================================================================
+func read_scenario(r io.Reader) ([]ScenarioStep, error) {
+
+	steps := []ScenarioStep{}
+
+	decoder := json.NewDecoder(r)
+
+	for {
+
+		var raw json.RawMessage
+
+		err := decoder.Decode(&raw)
+
+		if err == io.EOF {
+			return steps, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+
+			var batch []ScenarioStep
+
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return nil, err
+			}
+
+			steps = append(steps, batch...)
+			continue
+		}
+
+		var step ScenarioStep
+
+		if err := json.Unmarshal(raw, &step); err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, step)
+	}
+}
+
+//==============================================================================================================================
+//	 step_type - Returns the type of the step, looking the function up in the registry if the step gives none.
+//==============================================================================================================================
+func step_type(step ScenarioStep) string {
+
+	if step.Type != "" {
+		return step.Type
+	}
+
+	if step.Function == STEP_INIT {
+		return STEP_INIT
+	}
+
+	if _, ok := find_function(step.Function, QUERY); ok {
+		if _, ok := find_function(step.Function, INVOKE); !ok {
+			return STEP_QUERY
+		}
+	}
+
+	return STEP_INVOKE
+}
+
+//==============================================================================================================================
+//	 step_args - Returns the arguments of the call the step makes.
+//==============================================================================================================================
+func step_args(step ScenarioStep) ([]string, error) {
+
+	if step_type(step) == STEP_INIT {
+		return step.Args, nil
+	}
+
+	payload := bytes.TrimSpace(step.Payload)
+
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	if payload[0] == '"' {
+
+		var s string
+
+		if err := json.Unmarshal(payload, &s); err != nil {
+			return nil, err
+		}
+
+		return []string{s}, nil
+	}
+
+	return []string{string(payload)}, nil
+}
+
+//==============================================================================================================================
+//	 run_step - Makes the call of one step on the ledger and checks its expectations.
+//==============================================================================================================================
+func run_step(cc *SimpleChaincode, stub shim.ChaincodeStubInterface, l *replay_ledger, number int, step ScenarioStep) StepReport {
+
+	report := StepReport{Number: number, Step: step}
+
+	if step.Time != "" {
+
+		now, err := time.Parse(time.RFC3339, step.Time)
+
+		if err != nil {
+			report.Failures = append(report.Failures, "invalid time "+step.Time+", expected RFC3339")
+			return report
+		}
+
+		l.now = now
+	}
+
+	args, err := step_args(step)
+
+	if err != nil {
+		report.Failures = append(report.Failures, "invalid payload: "+err.Error())
+		return report
+	}
+
+	l.attrs = map[string]string{"username": step.Caller, "role": step.Role}
+	l.events = nil
+
+	before := l.snapshot()
+
+	switch step_type(step) {
+
+	case STEP_QUERY:
+		report.Result, report.Err = cc.Query(stub, step.Function, args)
+
+	case STEP_SEED:
+		err := l.seed(step.State)
+
+		if err != nil {
+			report.Failures = append(report.Failures, "invalid state: "+err.Error())
+			return report
+		}
+
+	case STEP_INIT, STEP_INVOKE:
+		l.txn++
+		l.mock.MockTransactionStart(fmt.Sprintf("replay-%06d", l.txn))
+
+		if step_type(step) == STEP_INIT {
+			report.Result, report.Err = cc.Init(stub, STEP_INIT, args)
+		} else {
+			report.Result, report.Err = cc.Invoke(stub, step.Function, args)
+		}
+
+		l.mock.MockTransactionEnd("")
+		l.now = l.now.Add(REPLAY_TICK)
+
+		if report.Err != nil {
+			l.restore(before)
+			l.events = nil
+		}
+
+	default:
+		report.Failures = append(report.Failures, "unknown step type "+step.Type+", expected init, invoke, query or seed")
+		return report
+	}
+
+	report.Diff = diff_state(before, l.mock.State)
+	report.Failures = append(report.Failures, check_expectations(step.Expect, report, l)...)
+
+	return report
+}
+
+//==============================================================================================================================
+//	 check_expectations - Returns the expectations of the step that do not hold.
+//==============================================================================================================================
+func check_expectations(expect StepExpectation, report StepReport, l *replay_ledger) []string {
+
+	failures := []string{}
+
+	if expect.Error != "" {
+
+		if report.Err == nil {
+			return append(failures, "expected error "+expect.Error+", the call succeeded")
+		}
+
+		if code := as_chaincode_error(report.Err, "").Code; code != expect.Error {
+			failures = append(failures, "expected error "+expect.Error+", got "+report.Err.Error())
+		}
+
+	} else if report.Err != nil {
+		return append(failures, "unexpected error "+report.Err.Error())
+	}
+
+	if len(expect.Result) > 0 {
+		if !json_subset(decode_value(expect.Result), decode_value(report.Result)) {
+			failures = append(failures, "result "+string(report.Result)+" does not match "+compact(expect.Result))
+		}
+	}
+
+	if len(expect.Event) > 0 {
+
+		matched := false
+
+		for _, event := range l.events {
+			matched = matched || json_subset(decode_value(expect.Event), decode_value(event))
+		}
+
+		if !matched {
+			failures = append(failures, "no event matches "+compact(expect.Event))
+		}
+	}
+
+	keys := []string{}
+
+	for key := range expect.State {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+
+		want := expect.State[key]
+		value, exists := l.mock.State[key]
+
+		if string(bytes.TrimSpace(want)) == "null" {
+			if exists {
+				failures = append(failures, "state "+key+" exists, expected none")
+			}
+			continue
+		}
+
+		if !exists {
+			failures = append(failures, "state "+key+" does not exist")
+			continue
+		}
+
+		if !json_subset(decode_value(want), decode_value(value)) {
+			failures = append(failures, "state "+key+" "+string(value)+" does not match "+compact(want))
+		}
+	}
+
+	return failures
+}
+
+//==============================================================================================================================
+//	 decode_value - Decodes a JSON value. Values that are no JSON, such as stored eCerts, are returned as strings.
+//==============================================================================================================================
+func decode_value(raw []byte) interface{} {
+
+	var value interface{}
+
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw)
+	}
+
+	return value
+}
+
+//==============================================================================================================================
+//	 json_subset - Returns true if actual holds want: objects must hold every field of want, arrays must have the same
+//				   length and match element by element, anything else must be equal.
+//==============================================================================================================================
+func json_subset(want interface{}, actual interface{}) bool {
+
+	switch w := want.(type) {
+
+	case map[string]interface{}:
+
+		a, ok := actual.(map[string]interface{})
+
+		if !ok {
+			return false
+		}
+
+		for name, value := range w {
+
+			field, exists := a[name]
+
+			if !exists || !json_subset(value, field) {
+				return false
+			}
+		}
+
+		return true
+
+	case []interface{}:
+
+		a, ok := actual.([]interface{})
+
+		if !ok || len(a) != len(w) {
+			return false
+		}
+
+		for i := range w {
+			if !json_subset(w[i], a[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return reflect.DeepEqual(want, actual)
+}
+
+//==============================================================================================================================
+//	 diff_state - Lists the world state changes between two snapshots. Changed JSON objects are listed field by field.
+//==============================================================================================================================
+func diff_state(before map[string][]byte, after map[string][]byte) []string {
+
+	keys := []string{}
+
+	for key := range before {
+		keys = append(keys, key)
+	}
+
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	diff := []string{}
+
+	for _, key := range keys {
+
+		old, had := before[key]
+		value, has := after[key]
+
+		switch {
+
+		case had && !has:
+			diff = append(diff, "- "+key)
+
+		case !had && has:
+			diff = append(diff, "+ "+key+" "+string(value))
+
+		case !bytes.Equal(old, value):
+
+			old_object, ok_old := decode_value(old).(map[string]interface{})
+			new_object, ok_new := decode_value(value).(map[string]interface{})
+
+			if !ok_old || !ok_new {
+				diff = append(diff, "~ "+key+" "+string(old)+" -> "+string(value))
+				continue
+			}
+
+			diff = append(diff, diff_fields(key, old_object, new_object)...)
+		}
+	}
+
+	return diff
+}
+
+//==============================================================================================================================
+//	 diff_fields - Lists the top level fields changed between two versions of the JSON record at key.
+//==============================================================================================================================
+func diff_fields(key string, before map[string]interface{}, after map[string]interface{}) []string {
+
+	names := []string{}
+
+	for name := range before {
+		names = append(names, name)
+	}
+
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	diff := []string{}
+
+	for _, name := range names {
+
+		old, _ := json.Marshal(before[name])
+		value, _ := json.Marshal(after[name])
+
+		if !bytes.Equal(old, value) {
+			diff = append(diff, "~ "+key+"."+name+" "+string(old)+" -> "+string(value))
+		}
+	}
+
+	return diff
+}
+
+//==============================================================================================================================
+//	 compact - Returns the JSON passed without insignificant white space.
+//==============================================================================================================================
+func compact(raw []byte) string {
+
+	var b bytes.Buffer
+
+	if err := json.Compact(&b, raw); err != nil {
+		return string(raw)
+	}
+
+	return b.String()
+}
+
+//==============================================================================================================================
+//	 clip - Shortens s to width characters, 0 meaning no limit.
+//==============================================================================================================================
+func clip(s string, width int) string {
+
+	if width > 0 && len(s) > width {
+		return s[:width] + "..."
+	}
+
+	return s
+}
+
+//==============================================================================================================================
+//	 print_report - Writes the outcome of a step. The state diff is written for every step when verbose, otherwise
+//					only for failed ones.
+//==============================================================================================================================
+func print_report(w io.Writer, report StepReport, verbose bool, width int) {
+
+	outcome := "PASS"
+
+	if len(report.Failures) > 0 {
+		outcome = "FAIL"
+	}
+
+	title := report.Step.Function
+
+	if report.Step.Name != "" {
+		title += " - " + report.Step.Name
+	}
+
+	if report.Step.Caller != "" || report.Step.Role != "" {
+		title += " [" + report.Step.Caller + "/" + report.Step.Role + "]"
+	}
+
+	fmt.Fprintf(w, "  %v %3d %v\n", outcome, report.Number, title)
+
+	for _, failure := range report.Failures {
+		fmt.Fprintf(w, "           ! %v\n", clip(failure, width))
+	}
+
+	if verbose || outcome == "FAIL" {
+		for _, line := range report.Diff {
+			fmt.Fprintf(w, "           %v\n", clip(line, width))
+		}
+	}
+}
+
+//==============================================================================================================================
+//	 replay_file - Replays one scenario file on an empty ledger. Returns the number of steps and of failed steps.
+//==============================================================================================================================
+func replay_file(w io.Writer, path string, verbose bool, width int) (int, int, error) {
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer file.Close()
+
+	steps, err := read_scenario(file)
+
+	if err != nil {
+		return 0, 0, errors.New(path + ": " + err.Error())
+	}
+
+	cc := new(SimpleChaincode)
+
+	stub, ledger := new_replay_stub(cc, (&shim.MockStub{}).GetTxTimestamp)
+
+	if len(steps) == 0 || (step_type(steps[0]) != STEP_INIT && step_type(steps[0]) != STEP_SEED) {
+		steps = append([]ScenarioStep{{Name: "implicit", Type: STEP_INIT, Function: STEP_INIT}}, steps...)
+	}
+
+	fmt.Fprintln(w, path)
+
+	failed := 0
+
+	for i, step := range steps {
+
+		report := run_step(cc, stub, ledger, i+1, step)
+
+		if len(report.Failures) > 0 {
+			failed++
+		}
+
+		print_report(w, report, verbose, width)
+	}
+
+	return len(steps), failed, nil
+}
+
+//==============================================================================================================================
+//	 run_replay - The replay command. Exits with 1 if a step failed and 2 if a scenario could not be read.
+//==============================================================================================================================
+func run_replay(args []string) int {
+
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+
+	verbose := flags.Bool("v", false, "print the state diff of every step, not only of failed ones")
+	width := flags.Int("width", 160, "shorten report lines to this many characters, 0 for no limit")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: replay [-v] [-width n] scenario.jsonl ...")
+		return 2
+	}
+
+	//the chaincode prints its own diagnostics, they go to stderr to keep the report readable
+	report := os.Stdout
+	os.Stdout = os.Stderr
+
+	defer func() { os.Stdout = report }()
+
+	total, failed := 0, 0
+
+	for _, path := range flags.Args() {
+
+		steps, failures, err := replay_file(report, path, *verbose, *width)
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+
+		total += steps
+		failed += failures
+	}
+
+	fmt.Fprintf(report, "%v steps, %v passed, %v failed\n", total, total-failed, failed)
+
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func init() {
+
+	replay = run_replay
+
+	logger.SetLevel(shim.LogCritical)
+	shim.SetLoggingLevel(shim.LogCritical)
+}
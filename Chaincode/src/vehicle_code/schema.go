@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Field error rules - Which check of the payload schema a field failed.
+//==============================================================================================================================
+const RULE_JSON = "json"
+const RULE_UNKNOWN = "unknown"
+const RULE_REQUIRED = "required"
+const RULE_TYPE = "type"
+const RULE_MAX_LENGTH = "maxLength"
+const RULE_PATTERN = "pattern"
+const RULE_RANGE = "range"
+
+//==============================================================================================================================
+//	 Character sets and ID formats shared by the field rules
+//==============================================================================================================================
+const PATTERN_TEXT = "^[^\\x00-\\x1f\\x7f]*$"  //printable, no control characters
+const PATTERN_ASSET_ID = "^[A-Za-z0-9]{1,24}$" //any deployment's format, createAsset checks the configured one
+const PATTERN_V5C_ID = "^[A-Za-z]{2}[0-9]{7}$"
+const PATTERN_PO = "^[0-9]{10}$"
+const PATTERN_IDENTITY = "^[A-Za-z0-9 _.@-]{1,64}$"
+const PATTERN_CODE = "^[A-Za-z_]{1,32}$"
+const PATTERN_DATE = "^([0-9]{8}|[0-9]{4}-[0-9]{2}-[0-9]{2})$" //SAP YYYYMMDD or ISO YYYY-MM-DD
+const PATTERN_BASE64 = "^(data:[A-Za-z0-9!#$&^_.+/-]*;base64,)?[A-Za-z0-9+/=\\r\\n]*$"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	FieldRule - The length limit and format a string field of a payload must keep to. Patterns are only applied to
+//				non-empty values, whether a field may be empty is decided by PayloadField.Required.
+//==============================================================================================================================
+type FieldRule struct {
+	MaxLength int
+	Pattern   string
+}
+
+//==============================================================================================================================
+//	FieldError - One field of a payload that failed the schema. Field is the path of the field, e.g. "asset.poDma".
+//==============================================================================================================================
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+//==============================================================================================================================
+//	 default_rule - The rule of string fields not listed in field_rules.
+//==============================================================================================================================
+var default_rule = FieldRule{MaxLength: 256, Pattern: PATTERN_TEXT}
+
+//==============================================================================================================================
+//	 field_rules - The rules of string fields by field name. Fields of the same name in different objects, e.g.
+//				   asset.ownerId and filter.ownerId, share a rule.
+//==============================================================================================================================
+var field_rules = map[string]FieldRule{
+	"assetID":         {MaxLength: 24, Pattern: PATTERN_ASSET_ID},
+	"caller":          {MaxLength: 64, Pattern: PATTERN_IDENTITY},
+	"ownerId":         {MaxLength: 64, Pattern: PATTERN_IDENTITY},
+	"recipient":       {MaxLength: 64, Pattern: PATTERN_IDENTITY},
+	"transactionType": {MaxLength: 32, Pattern: "^[A-Za-z0-9 _-]{1,32}$"},
+	"status":          {MaxLength: 32, Pattern: PATTERN_CODE},
+	"matnrAf":         {MaxLength: 40, Pattern: "^[A-Za-z0-9_./-]{1,40}$"},
+	"poDma":           {MaxLength: 10, Pattern: PATTERN_PO},
+	"poSupp":          {MaxLength: 10, Pattern: PATTERN_PO},
+	"dmaDelDate":      {MaxLength: 10, Pattern: PATTERN_DATE},
+	"afDelDate":       {MaxLength: 10, Pattern: PATTERN_DATE},
+	"truckPdate":      {MaxLength: 10, Pattern: PATTERN_DATE},
+	"dmaMasdat":       {MaxLength: 10, Pattern: PATTERN_DATE},
+	"dmaDelDateFrom":  {MaxLength: 10, Pattern: PATTERN_DATE},
+	"dmaDelDateTo":    {MaxLength: 10, Pattern: PATTERN_DATE},
+	"afDelDateFrom":   {MaxLength: 10, Pattern: PATTERN_DATE},
+	"afDelDateTo":     {MaxLength: 10, Pattern: PATTERN_DATE},
+	"truckMod":        {MaxLength: 40, Pattern: PATTERN_TEXT},
+	"truckChnum":      {MaxLength: 30, Pattern: "^[A-Za-z0-9-]{1,30}$"},
+	"truckEnnum":      {MaxLength: 30, Pattern: "^[A-Za-z0-9-]{1,30}$"},
+	"afDoc":           {MaxLength: MAX_DOC_SIZE, Pattern: PATTERN_BASE64},
+	"content":         {MaxLength: MAX_DOC_SIZE, Pattern: PATTERN_BASE64},
+	"docId":           {MaxLength: 6, Pattern: "^[0-9]{6}$"},
+	"docType":         {MaxLength: 32, Pattern: PATTERN_CODE},
+	"fileName":        {MaxLength: 255, Pattern: PATTERN_TEXT},
+	"mimeType":        {MaxLength: 127, Pattern: "^[A-Za-z0-9!#$&^_.+-]+/[A-Za-z0-9!#$&^_.+-]+$"},
+	"sha256":          {MaxLength: 64, Pattern: "^[0-9A-Fa-f]{64}$"},
+	"uploadId":        {MaxLength: 128, Pattern: "^[A-Za-z0-9-]{1,128}$"},
+	"uri":             {MaxLength: 2048, Pattern: "^[^\\s]+$"},
+	"expiresAt":       {MaxLength: 35, Pattern: "^[0-9TZ:.+-]{1,35}$"},
+	"sortBy":          {MaxLength: 32, Pattern: PATTERN_CODE},
+	"format":          {MaxLength: 6, Pattern: "^(legacy|raw)$"},
+	"messageId":       {MaxLength: 128, Pattern: "^[A-Za-z0-9_.:/-]{1,128}$"},
+	"keyField":        {MaxLength: 7, Pattern: "^(poDma|poSupp|matnrAf)$"},
+	"keyValue":        {MaxLength: 40, Pattern: "^[A-Za-z0-9_./-]{1,40}$"},
+	"assetType":       {MaxLength: 32, Pattern: PATTERN_TYPE_NAME},
+	"make":            {MaxLength: 40, Pattern: PATTERN_TEXT},
+	"model":           {MaxLength: 40, Pattern: PATTERN_TEXT},
+	"reg":             {MaxLength: 10, Pattern: "^[A-Za-z0-9 ]{1,10}$"},
+	"VIN":             {MaxLength: 15, Pattern: "^[0-9]{15}$"},
+	"colour":          {MaxLength: 40, Pattern: PATTERN_TEXT},
+	"recipientRole":   {MaxLength: 32, Pattern: PATTERN_CODE},
+
+	//the settings of updateConfig
+	"identityMode":      {MaxLength: 6, Pattern: "^(strict|legacy)$"},
+	"assetIdMode":       {MaxLength: 8, Pattern: "^(client|allocate)$"},
+	"assetIdPrefix":     {MaxLength: 8, Pattern: PATTERN_ASSET_ID_PREFIX},
+	"assetIdCheckDigit": {MaxLength: 4, Pattern: "^(none|luhn)$"},
+	"admins":            {MaxLength: 64, Pattern: PATTERN_IDENTITY},
+
+	//the asset type definitions of registerAssetType
+	"type":     {MaxLength: 7, Pattern: "^(string|number|boolean|date)$"},
+	"roles":    {MaxLength: 3, Pattern: "^[A-Z]{2,3}$"},
+	"stages":   {MaxLength: 32, Pattern: PATTERN_CODE},
+	"statuses": {MaxLength: 32, Pattern: PATTERN_CODE},
+	"from":     {MaxLength: 32, Pattern: PATTERN_CODE},
+	"to":       {MaxLength: 32, Pattern: PATTERN_CODE},
+}
+
+//==============================================================================================================================
+//	 always_allowed - Fields any payload may carry. In legacy identity mode the caller is taken from asset.caller, so
+//					  it is accepted even by functions that do not read the asset.
+//==============================================================================================================================
+var always_allowed = fields_payload("asset", []string{"caller"})
+
+//==============================================================================================================================
+//	 patterns - The compiled patterns of the registered payloads, see compile_patterns.
+//==============================================================================================================================
+var patterns = map[string]*regexp.Regexp{}
+
+//==============================================================================================================================
+//	 field_rule - Returns the rule of the field name passed.
+//==============================================================================================================================
+func field_rule(name string) FieldRule {
+
+	if rule, ok := field_rules[name]; ok {
+		return rule
+	}
+
+	return default_rule
+}
+
+//==============================================================================================================================
+//	 with_rule - Returns a copy of payload in which the field at path follows rule instead of the shared one.
+//==============================================================================================================================
+func with_rule(payload []PayloadField, path string, rule FieldRule) []PayloadField {
+
+	changed := join_payloads(payload)
+
+	for i := range changed {
+		if changed[i].Name == path {
+			changed[i].MaxLength = rule.MaxLength
+			changed[i].Pattern = rule.Pattern
+		}
+	}
+
+	return changed
+}
+
+//==============================================================================================================================
+//	 compile_patterns - Compiles the patterns of every registered payload once, at start up.
+//==============================================================================================================================
+func compile_patterns(registry []ChaincodeFunction) {
+
+	for _, f := range registry {
+		compile_payload(join_payloads(f.Payload, always_allowed))
+	}
+}
+
+//==============================================================================================================================
+//	 compile_payload - Compiles the patterns of the fields of payload and of the objects of its arrays.
+//==============================================================================================================================
+func compile_payload(payload []PayloadField) {
+
+	for _, field := range payload {
+
+		if field.Pattern != "" && patterns[field.Pattern] == nil {
+			patterns[field.Pattern] = regexp.MustCompile(field.Pattern)
+		}
+
+		compile_payload(field.Items)
+	}
+}
+
+//==============================================================================================================================
+//	 declared - Returns true if path is a field of payload, or an object holding fields of payload.
+//==============================================================================================================================
+func declared(payload []PayloadField, path string) (field bool, object bool) {
+
+	for _, p := range payload {
+
+		if p.Name == path {
+			field = true
+		}
+
+		if strings.HasPrefix(p.Name, path+".") {
+			object = true
+		}
+	}
+
+	return field, object
+}
+
+//==============================================================================================================================
+//	 unknown_fields - Returns the paths below prefix that payload does not declare.
+//==============================================================================================================================
+func unknown_fields(payload []PayloadField, raw map[string]interface{}, prefix string) []string {
+
+	unknown := []string{}
+
+	for name, value := range raw {
+
+		path := prefix + name
+
+		field, object := declared(payload, path)
+
+		if field {
+			continue
+		}
+
+		nested, ok := value.(map[string]interface{})
+
+		if object && ok {
+			unknown = append(unknown, unknown_fields(payload, nested, path+".")...)
+			continue
+		}
+
+		unknown = append(unknown, path)
+	}
+
+	sort.Strings(unknown)
+
+	return unknown
+}
+
+//==============================================================================================================================
+//	 check_field - Checks one declared field of a payload. Returns nil if it passes.
+//==============================================================================================================================
+func check_field(field PayloadField, value interface{}) *FieldError {
+
+	if value == nil || value == "" {
+
+		if field.Required {
+			return &FieldError{Field: field.Name, Rule: RULE_REQUIRED, Message: field.Name + " is required"}
+		}
+
+		return nil
+	}
+
+	if field.Type == "number" {
+
+		n, ok := value.(json.Number)
+
+		if !ok {
+			return &FieldError{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be a number"}
+		}
+
+		i, err := n.Int64()
+
+		if err != nil || i < 0 {
+			return &FieldError{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be a whole number of 0 or more"}
+		}
+
+		return nil
+	}
+
+	if field.Type == "boolean" {
+
+		if _, ok := value.(bool); !ok {
+			return &FieldError{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be true or false"}
+		}
+
+		return nil
+	}
+
+	if field.Type == "object" {
+
+		if _, ok := value.(map[string]interface{}); !ok {
+			return &FieldError{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be an object"}
+		}
+
+		return nil
+	}
+
+	if field.Type == "string[]" {
+
+		list, ok := value.([]interface{})
+
+		if !ok {
+			return &FieldError{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be a list of strings"}
+		}
+
+		for i, item := range list {
+
+			named := field
+			named.Name = fmt.Sprintf("%v[%v]", field.Name, i)
+			named.Type = "string"
+
+			if e := check_field(named, item); e != nil {
+				return e
+			}
+		}
+
+		return nil
+	}
+
+	s, ok := value.(string)
+
+	if !ok {
+		return &FieldError{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be a string"}
+	}
+
+	if field.MaxLength > 0 && len(s) > field.MaxLength {
+		return &FieldError{Field: field.Name, Rule: RULE_MAX_LENGTH, Message: fmt.Sprintf("%v may be at most %v characters long", field.Name, field.MaxLength)}
+	}
+
+	if pattern := patterns[field.Pattern]; pattern != nil && !pattern.MatchString(s) {
+		return &FieldError{Field: field.Name, Rule: RULE_PATTERN, Message: fmt.Sprintf("%v does not match %v", field.Name, field.Pattern)}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 check_items - Checks a declared array field of a payload and every object in it. The paths of the errors of an
+//				   object start with the field and its index, e.g. "assets[2].asset.poDma".
+//==============================================================================================================================
+func check_items(field PayloadField, value interface{}) []FieldError {
+
+	if value == nil {
+
+		if field.Required {
+			return []FieldError{{Field: field.Name, Rule: RULE_REQUIRED, Message: field.Name + " is required"}}
+		}
+
+		return nil
+	}
+
+	items, ok := value.([]interface{})
+
+	if !ok {
+		return []FieldError{{Field: field.Name, Rule: RULE_TYPE, Message: field.Name + " must be an array"}}
+	}
+
+	if field.Required && len(items) == 0 {
+		return []FieldError{{Field: field.Name, Rule: RULE_REQUIRED, Message: field.Name + " must hold at least one item"}}
+	}
+
+	if field.MaxLength > 0 && len(items) > field.MaxLength {
+		return []FieldError{{Field: field.Name, Rule: RULE_MAX_LENGTH, Message: fmt.Sprintf("%v may hold at most %v items", field.Name, field.MaxLength)}}
+	}
+
+	errs := []FieldError{}
+
+	for i, item := range items {
+
+		prefix := fmt.Sprintf("%v[%v]", field.Name, i)
+
+		object, ok := item.(map[string]interface{})
+
+		if !ok {
+			errs = append(errs, FieldError{Field: prefix, Rule: RULE_TYPE, Message: prefix + " must be an object"})
+			continue
+		}
+
+		errs = append(errs, check_object(field.Items, object, prefix+".", field.Name)...)
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 check_object - Checks an object against payload. prefix is put in front of the paths of the errors, owner names
+//					what the object belongs to in the message of unknown fields.
+//==============================================================================================================================
+func check_object(payload []PayloadField, raw map[string]interface{}, prefix string, owner string) []FieldError {
+
+	errs := []FieldError{}
+
+	for _, path := range unknown_fields(payload, raw, "") {
+		errs = append(errs, FieldError{Field: prefix + path, Rule: RULE_UNKNOWN, Message: prefix + path + " is not a field of " + owner})
+	}
+
+	for _, field := range payload {
+
+		value := lookup_path(raw, field.Name)
+
+		if field.Type == "array" {
+
+			for _, e := range check_items(field, value) {
+				e.Field = prefix + e.Field
+				e.Message = prefix + e.Message
+				errs = append(errs, e)
+			}
+
+			continue
+		}
+
+		named := field
+		named.Name = prefix + field.Name
+
+		if e := check_field(named, value); e != nil {
+			errs = append(errs, *e)
+		}
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 validate_payload - Checks the JSON argument of a call against the payload schema of its function before anything
+//						is read from it. Returns an ERR_INVALID_INPUT error listing every field
+//						that failed.
+//==============================================================================================================================
+func validate_payload(f ChaincodeFunction, payload string) error {
+
+	var raw map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(payload)))
+	decoder.UseNumber()
+
+	err := decoder.Decode(&raw)
+
+	if err != nil || raw == nil || decoder.More() {
+
+		message := "the argument must be a single JSON object"
+
+		if err != nil {
+			message = err.Error()
+		}
+
+		return ChaincodeError{Code: ERR_INVALID_JSON, Message: f.Name + ": Invalid JSON argument", Errors: []FieldError{{Rule: RULE_JSON, Message: message}}}
+	}
+
+	fields := f.Payload
+
+	for _, field := range always_allowed {
+		if known, _ := declared(fields, field.Name); !known {
+			fields = join_payloads(fields, []PayloadField{field})
+		}
+	}
+
+	errs := check_object(fields, raw, "", f.Name)
+
+	if len(errs) > 0 {
+		return invalid_input(f.Name, "Invalid payload", errs)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCheckField(t *testing.T) {
+
+	po := PayloadField{Name: "asset.poDma", Type: "string", MaxLength: 10, Pattern: PATTERN_PO}
+	required := PayloadField{Name: "asset.assetID", Type: "string", Required: true, MaxLength: 24, Pattern: PATTERN_ASSET_ID}
+	size := PayloadField{Name: "page.size", Type: "number"}
+	flag := PayloadField{Name: "options.all", Type: "boolean"}
+	object := PayloadField{Name: "config.roles", Type: "object"}
+	roles := PayloadField{Name: "definition.roles", Type: "string[]", MaxLength: 3, Pattern: "^[A-Z]{2,3}$"}
+	text := PayloadField{Name: "asset.truckMod", Type: "string", Pattern: PATTERN_TEXT}
+
+	compile_payload([]PayloadField{po, required, roles, text})
+
+	cases := []struct {
+		name  string
+		field PayloadField
+		value interface{}
+		want  string
+	}{
+		{"optional field left out", po, nil, ""},
+		{"optional field empty", po, "", ""},
+		{"required field left out", required, nil, "asset.assetID " + RULE_REQUIRED},
+		{"required field empty", required, "", "asset.assetID " + RULE_REQUIRED},
+		{"string matching its pattern", po, "4500000001", ""},
+		{"string not matching its pattern", po, "45000000A1", "asset.poDma " + RULE_PATTERN},
+		{"string too long", po, "45000000011", "asset.poDma " + RULE_MAX_LENGTH},
+		{"number for a string", po, json.Number("4500000001"), "asset.poDma " + RULE_TYPE},
+		{"whole number", size, json.Number("25"), ""},
+		{"zero", size, json.Number("0"), ""},
+		{"negative number", size, json.Number("-1"), "page.size " + RULE_TYPE},
+		{"fraction", size, json.Number("2.5"), "page.size " + RULE_TYPE},
+		{"string for a number", size, "25", "page.size " + RULE_TYPE},
+		{"flag", flag, false, ""},
+		{"string for a flag", flag, "true", "options.all " + RULE_TYPE},
+		{"object", object, map[string]interface{}{"AUDITOR": "REG"}, ""},
+		{"list for an object", object, []interface{}{"REG"}, "config.roles " + RULE_TYPE},
+		{"list of strings", roles, []interface{}{"REG", "SUP"}, ""},
+		{"string for a list", roles, "REG", "definition.roles " + RULE_TYPE},
+		{"item of another type", roles, []interface{}{"REG", true}, "definition.roles[1] " + RULE_TYPE},
+		{"item not matching the pattern", roles, []interface{}{"REG", "sup"}, "definition.roles[1] " + RULE_PATTERN},
+		{"item too long", roles, []interface{}{"REGS"}, "definition.roles[0] " + RULE_MAX_LENGTH},
+		{"control character", text, "FH\n16", "asset.truckMod " + RULE_PATTERN},
+	}
+
+	for _, c := range cases {
+
+		got := ""
+
+		if e := check_field(c.field, c.value); e != nil {
+			got = e.Field + " " + e.Rule
+
+			if !strings.HasPrefix(e.Message, e.Field) {
+				t.Errorf("%v: message %q does not name the field %v", c.name, e.Message, e.Field)
+			}
+		}
+
+		if got != c.want {
+			t.Errorf("%v: check_field(%v, %#v) = %q, want %q", c.name, c.field.Name, c.value, got, c.want)
+		}
+	}
+}
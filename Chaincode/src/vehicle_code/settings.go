@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Identity modes - How the router establishes who is calling.
+//					  IDENTITY_STRICT takes caller and role from the eCert attributes only, IDENTITY_LEGACY trusts the
+//					  caller passed in the JSON payload and is only meant to be used while clients are migrated.
+//==============================================================================================================================
+const IDENTITY_STRICT = "strict"
+const IDENTITY_LEGACY = "legacy"
+
+//==============================================================================================================================
+//	 Features - Groups of functions the regulator may switch off, see ChaincodeFunction.Feature.
+//==============================================================================================================================
+const FEATURE_BATCH_CREATE = "batchCreate"
+const FEATURE_EXTERNAL_UPDATES = "externalUpdates"
+const FEATURE_TRANSFERS = "transfers"
+const FEATURE_UPLOADS = "uploads"
+const FEATURE_LEGACY_FUNCTIONS = "legacyFunctions"
+
+var features = []string{FEATURE_BATCH_CREATE, FEATURE_EXTERNAL_UPDATES, FEATURE_TRANSFERS, FEATURE_UPLOADS, FEATURE_LEGACY_FUNCTIONS}
+
+//==============================================================================================================================
+//	 Configuration keys - SETTINGS_KEY holds the current Settings record, change n of it is stored at
+//						  CONFIG_HISTORY_PREFIX<n, zero padded>.
+//==============================================================================================================================
+const SETTINGS_KEY = "settings"
+const CONFIG_HISTORY_PREFIX = "confighistory~"
+
+//==============================================================================================================================
+//	 participant_codes - The participant constants by the code the configuration maps roles onto. The codes carry no
+//						 padding, so AF is "AF" here although the constant is "AF ".
+//==============================================================================================================================
+var participant_codes = map[string]string{
+	"REG": REGULATOR,
+	"AF":  AF,
+	"DMA": DMA,
+	"SUP": SUPPLIER,
+	"TRP": TRANSPORTER,
+	"MAN": MANUFACTURER,
+	"PRV": PRIVATE_ENTITY,
+	"LSE": LEASE_COMPANY,
+	"SCR": SCRAP_MERCHANT,
+}
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	Settings - The chaincode configuration. Written by Init from the name/value pairs passed at deploy time and changed
+//			   by the regulator with updateConfig. Version counts the changes, see ConfigChange.
+//
+//			   IdPatterns holds patterns for the business keys, by field, checked on every write on top of the payload
+//			   rules. Roles maps the values of the 'role' eCert attribute, upper case, onto participant codes, see
+//			   participant_codes. Features not listed are on. Admins, if any, are the only regulators who may
+//			   change the configuration.
+//==============================================================================================================================
+type Settings struct {
+	Version           int               `json:"version"`
+	IdentityMode      string            `json:"identityMode"`
+	DateHorizonDays   int               `json:"dateHorizonDays"` //how far past the transaction a date may lie
+	AssetIdMode       string            `json:"assetIdMode"`
+	AssetIdPrefix     string            `json:"assetIdPrefix"`
+	AssetIdWidth      int               `json:"assetIdWidth"` //digits after the prefix, check digit included
+	AssetIdCheckDigit string            `json:"assetIdCheckDigit"`
+	IdPatterns        map[string]string `json:"idPatterns"`
+	MaxDocSize        int               `json:"maxDocSize"`
+	MaxBatchSize      int               `json:"maxBatchSize"`
+	DefaultPageSize   int               `json:"defaultPageSize"`
+	MaxPageSize       int               `json:"maxPageSize"`
+	Roles             map[string]string `json:"roles"`
+	Features          map[string]bool   `json:"features"`
+	Admins            []string          `json:"admins"`
+}
+
+//==============================================================================================================================
+//	ConfigChange - One change of the configuration: which transaction made it, when, who called which function and
+//				   the settings it changed, each as JSON.
+//==============================================================================================================================
+type ConfigChange struct {
+	Version   int           `json:"version"`
+	TxID      string        `json:"txnid"`
+	Timestamp string        `json:"txnts"`
+	Caller    string        `json:"caller"`
+	Function  string        `json:"function"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+//==============================================================================================================================
+//	 default_settings - The settings used for anything not passed at deploy time.
+//==============================================================================================================================
+func default_settings() Settings {
+
+	return Settings{
+		IdentityMode:      IDENTITY_STRICT,
+		DateHorizonDays:   DEFAULT_DATE_HORIZON_DAYS,
+		AssetIdMode:       ASSET_IDS_CLIENT,
+		AssetIdWidth:      10,
+		AssetIdCheckDigit: CHECK_DIGIT_NONE,
+		IdPatterns:        map[string]string{},
+		MaxDocSize:        MAX_DOC_SIZE,
+		MaxBatchSize:      MAX_BATCH_SIZE,
+		DefaultPageSize:   DEFAULT_PAGE_SIZE,
+		MaxPageSize:       MAX_PAGE_SIZE,
+		Roles: map[string]string{
+			"REG":            "REG",
+			"REGULATOR":      "REG",
+			"AF":             "AF",
+			"DMA":            "DMA",
+			"SUP":            "SUP",
+			"SUPPLIER":       "SUP",
+			"TRP":            "TRP",
+			"TRANSPORTER":    "TRP",
+			"MAN":            "MAN",
+			"MANUFACTURER":   "MAN",
+			"PRV":            "PRV",
+			"PRIVATE":        "PRV",
+			"LSE":            "LSE",
+			"LEASE_COMPANY":  "LSE",
+			"SCR":            "SCR",
+			"SCRAP_MERCHANT": "SCR",
+		},
+		Features: map[string]bool{},
+		Admins:   []string{},
+	}
+}
+
+//==============================================================================================================================
+//	 copy - Returns a copy of the settings that shares no maps or slices with them.
+//==============================================================================================================================
+func (s Settings) copy() Settings {
+
+	c := s
+
+	c.IdPatterns, c.Roles, c.Features = map[string]string{}, map[string]string{}, map[string]bool{}
+
+	for k, v := range s.IdPatterns {
+		c.IdPatterns[k] = v
+	}
+
+	for k, v := range s.Roles {
+		c.Roles[k] = v
+	}
+
+	for k, v := range s.Features {
+		c.Features[k] = v
+	}
+
+	c.Admins = append([]string{}, s.Admins...)
+
+	return c
+}
+
+//==============================================================================================================================
+//	 merge_config - Sets the settings present in the JSON object passed, leaving the others. Maps are merged key by
+//					key; a business key or role mapped to "" is removed.
+//==============================================================================================================================
+func (s *Settings) merge_config(raw []byte) error {
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(s)
+
+	if err != nil {
+		return new_error(ERR_INVALID_INPUT, "Invalid configuration: not a JSON object of settings").on_field("config")
+	}
+
+	for k, v := range s.IdPatterns {
+		if v == "" {
+			delete(s.IdPatterns, k)
+		}
+	}
+
+	for k, v := range s.Roles {
+		if v == "" {
+			delete(s.Roles, k)
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//	 apply_setting - Sets the setting called name if it is one. Returns false if name is not a setting, in which case
+//					 Init treats the pair as a user and eCert. Values are typed as in the payload of updateConfig;
+//					 objects and lists are passed as JSON text.
+//==============================================================================================================================
+func (s *Settings) apply_setting(name string, value string) (bool, error) {
+
+	field, ok := find_payload_field(config_payload, "config."+name)
+
+	if !ok {
+		return false, nil
+	}
+
+	raw := value
+
+	if field.Type == "string" {
+		quoted, _ := json.Marshal(value)
+		raw = string(quoted)
+	}
+
+	err := s.merge_config([]byte(`{"` + name + `":` + raw + `}`))
+
+	if err != nil {
+		return true, new_error(ERR_INVALID_INPUT, "Invalid "+name+" "+value+", expected a "+field.Type)
+	}
+
+	return true, nil
+}
+
+//==============================================================================================================================
+//	 check - Returns the errors of the settings, each on its path below prefix, e.g. "config.".
+//==============================================================================================================================
+func (s Settings) check(prefix string) []FieldError {
+
+	errs := []FieldError{}
+
+	invalid := func(name string, rule string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: prefix + name, Rule: rule, Message: prefix + name + " " + fmt.Sprintf(format, args...)})
+	}
+
+	in_range := func(name string, value int, min int, max int) {
+		if value < min || value > max {
+			invalid(name, RULE_RANGE, "must be %v to %v", min, max)
+		}
+	}
+
+	if s.IdentityMode != IDENTITY_STRICT && s.IdentityMode != IDENTITY_LEGACY {
+		invalid("identityMode", RULE_PATTERN, "must be %v or %v", IDENTITY_STRICT, IDENTITY_LEGACY)
+	}
+
+	if s.DateHorizonDays < 0 {
+		invalid("dateHorizonDays", RULE_RANGE, "must be 0 or more")
+	}
+
+	if s.AssetIdMode != ASSET_IDS_CLIENT && s.AssetIdMode != ASSET_IDS_ALLOCATE {
+		invalid("assetIdMode", RULE_PATTERN, "must be %v or %v", ASSET_IDS_CLIENT, ASSET_IDS_ALLOCATE)
+	}
+
+	if !regexp.MustCompile(PATTERN_ASSET_ID_PREFIX).MatchString(s.AssetIdPrefix) {
+		invalid("assetIdPrefix", RULE_PATTERN, "does not match %v", PATTERN_ASSET_ID_PREFIX)
+	}
+
+	in_range("assetIdWidth", s.AssetIdWidth, MIN_ASSET_ID_WIDTH, MAX_ASSET_ID_WIDTH)
+
+	if s.AssetIdCheckDigit != CHECK_DIGIT_NONE && s.AssetIdCheckDigit != CHECK_DIGIT_LUHN {
+		invalid("assetIdCheckDigit", RULE_PATTERN, "must be %v or %v", CHECK_DIGIT_NONE, CHECK_DIGIT_LUHN)
+	}
+
+	for _, field := range sorted_keys(s.IdPatterns) {
+
+		if !is_lookup_field(field) {
+			invalid("idPatterns."+field, RULE_UNKNOWN, "is not a business key")
+			continue
+		}
+
+		_, err := regexp.Compile(s.IdPatterns[field])
+
+		if err != nil {
+			invalid("idPatterns."+field, RULE_PATTERN, "is not a valid pattern: %v", err)
+		}
+	}
+
+	in_range("maxDocSize", s.MaxDocSize, 1, MAX_DOC_SIZE)
+	in_range("maxBatchSize", s.MaxBatchSize, 1, MAX_BATCH_SIZE)
+	in_range("maxPageSize", s.MaxPageSize, 1, MAX_PAGE_SIZE)
+	in_range("defaultPageSize", s.DefaultPageSize, 1, s.MaxPageSize)
+
+	for _, role := range sorted_keys(s.Roles) {
+
+		if role != strings.ToUpper(strings.TrimSpace(role)) || role == "" {
+			invalid("roles."+role, RULE_PATTERN, "must be upper case without surrounding spaces")
+		}
+
+		if _, ok := participant_codes[s.Roles[role]]; !ok {
+			invalid("roles."+role, RULE_PATTERN, "must map onto one of %v", sorted_keys(participant_codes))
+		}
+	}
+
+	for _, feature := range sorted_keys(s.Features) {
+		if !contains(features, feature) {
+			invalid("features."+feature, RULE_UNKNOWN, "is not a feature, expected one of %v", features)
+		}
+	}
+
+	for i, admin := range s.Admins {
+		if !regexp.MustCompile(PATTERN_IDENTITY).MatchString(admin) {
+			invalid(fmt.Sprintf("admins[%v]", i), RULE_PATTERN, "does not match %v", PATTERN_IDENTITY)
+		}
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 sorted_keys - Returns the keys of the map passed in order.
+//==============================================================================================================================
+func sorted_keys(m interface{}) []string {
+
+	keys := []string{}
+
+	switch m := m.(type) {
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]bool:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string][]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]interface{}:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+//==============================================================================================================================
+//	 participant_role - Returns the participant constant for the role attribute passed, e.g. "dma" -> DMA, "AF" -> AF.
+//==============================================================================================================================
+func (s Settings) participant_role(role string) (string, bool) {
+
+	participant, ok := participant_codes[s.Roles[strings.ToUpper(strings.TrimSpace(role))]]
+
+	return participant, ok
+}
+
+//==============================================================================================================================
+//	 feature_enabled - Returns false if the regulator switched the feature off. Functions of no feature are always on.
+//==============================================================================================================================
+func (s Settings) feature_enabled(feature string) bool {
+
+	on, listed := s.Features[feature]
+
+	return feature == "" || !listed || on
+}
+
+//==============================================================================================================================
+//	 page_size - Returns the page size for the size requested: the configured default if none is, at most the
+//				 configured maximum.
+//==============================================================================================================================
+func (s Settings) page_size(size int) int {
+
+	if size <= 0 {
+		return s.DefaultPageSize
+	}
+
+	if size > s.MaxPageSize {
+		return s.MaxPageSize
+	}
+
+	return size
+}
+
+//==============================================================================================================================
+//	 may_configure - Returns true if the caller may change the configuration: any regulator unless admins are named.
+//==============================================================================================================================
+func (s Settings) may_configure(caller string, caller_affiliation string) bool {
+
+	return caller_affiliation == REGULATOR && (len(s.Admins) == 0 || contains(s.Admins, caller))
+}
+
+//==============================================================================================================================
+//	 config_changes - Returns the settings that differ between before and after, each as JSON.
+//==============================================================================================================================
+func config_changes(before Settings, after Settings) []FieldChange {
+
+	as_map := func(s Settings) map[string]json.RawMessage {
+
+		s.Version = 0
+
+		bytes, _ := json.Marshal(s)
+
+		m := map[string]json.RawMessage{}
+		json.Unmarshal(bytes, &m)
+
+		return m
+	}
+
+	old, updated := as_map(before), as_map(after)
+
+	names := []string{}
+
+	for name := range updated {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	changes := []FieldChange{}
+
+	for _, name := range names {
+		if !bytes.Equal(old[name], updated[name]) {
+			changes = append(changes, FieldChange{Field: name, Old: string(old[name]), New: string(updated[name])})
+		}
+	}
+
+	return changes
+}
+
+//==============================================================================================================================
+//	 config_history_key - Returns the world state key of change n of the configuration.
+//==============================================================================================================================
+func config_history_key(n int) string {
+
+	return fmt.Sprintf("%v%010d", CONFIG_HISTORY_PREFIX, n)
+}
+
+//==============================================================================================================================
+//	 retrieve_settings - Reads the Settings record from the ledger. Deployments that predate it, or a setting, get
+//						 the defaults.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_settings(stub shim.ChaincodeStubInterface) (Settings, error) {
+
+	s := default_settings()
+
+	bytes, err := stub.GetState(SETTINGS_KEY)
+
+	if err != nil {
+		return s, errors.New("Unable to get settings")
+	}
+
+	if bytes == nil {
+		return s, nil
+	}
+
+	stored := s
+	stored.IdPatterns, stored.Roles, stored.Features = nil, nil, nil
+
+	err = json.Unmarshal(bytes, &stored)
+
+	if err != nil {
+		return s, errors.New("Corrupt settings record")
+	}
+
+	if stored.IdPatterns == nil {
+		stored.IdPatterns = s.IdPatterns
+	}
+
+	if stored.Roles == nil {
+		stored.Roles = s.Roles
+	}
+
+	if stored.Features == nil {
+		stored.Features = s.Features
+	}
+
+	if stored.Admins == nil {
+		stored.Admins = s.Admins
+	}
+
+	return stored, nil
+}
+
+//==============================================================================================================================
+//	 save_settings - Writes after as the next version of the Settings record and records how it differs from before.
+//					 Nothing is written if nothing changed.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_settings(stub shim.ChaincodeStubInterface, before Settings, after Settings, caller string, function string) (Settings, error) {
+
+	changes := config_changes(before, after)
+
+	if len(changes) == 0 {
+		return before, nil
+	}
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return after, err
+	}
+
+	after.Version = before.Version + 1
+
+	change := ConfigChange{Version: after.Version, TxID: stub.GetTxID(), Timestamp: now.Format(time.RFC3339), Caller: caller, Function: function, Changes: changes}
+
+	bytes, err := json.Marshal(change)
+
+	if err != nil {
+		return after, errors.New("Error converting configuration change record")
+	}
+
+	err = stub.PutState(config_history_key(change.Version), bytes)
+
+	if err != nil {
+		return after, errors.New("Error storing configuration change record")
+	}
+
+	bytes, err = json.Marshal(after)
+
+	if err != nil {
+		return after, errors.New("Error converting settings record")
+	}
+
+	err = stub.PutState(SETTINGS_KEY, bytes)
+
+	if err != nil {
+		return after, errors.New("Error storing settings record")
+	}
+
+	return after, nil
+}
+
+//=================================================================================================================================
+//	 updateConfig - Changes the settings present in the "config" object passed, see merge_config, and returns the new
+//					configuration. The whole configuration is checked before it is stored.
+//=================================================================================================================================
+func (t *SimpleChaincode) updateConfig(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, config json.RawMessage) ([]byte, error) {
+
+	before, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !before.may_configure(caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. updateConfig. Only the admins "+strings.Join(before.Admins, ", ")+" may change the configuration")
+	}
+
+	after := before.copy()
+
+	err = after.merge_config(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := after.check("config."); len(errs) > 0 {
+		return nil, invalid_input("updateConfig", "the configuration would be invalid", errs)
+	}
+
+	after, err = t.save_settings(stub, before, after, caller, "updateConfig")
+
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(after)
+
+	if err != nil {
+		return nil, errors.New("UPDATECONFIG: Error converting configuration")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 readConfig - Returns the configuration and a page of its changes, oldest first. The bookmark of the response is
+//				  passed back to get the next page and is empty on the last one. Only those who may change the
+//				  configuration may read it, as it names the admins and the role mapping.
+//=================================================================================================================================
+func (t *SimpleChaincode) readConfig(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, page PageRequest) ([]byte, error) {
+
+	s, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.may_configure(caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readConfig. Only the admins "+strings.Join(s.Admins, ", ")+" may read the configuration")
+	}
+
+	from := 1
+
+	if page.Bookmark != "" {
+
+		from, err = strconv.Atoi(page.Bookmark)
+
+		if err != nil || from < 1 {
+			return nil, new_error(ERR_INVALID_INPUT, "Invalid bookmark "+page.Bookmark).on_field("page.bookmark")
+		}
+	}
+
+	changes := []ConfigChange{}
+
+	n := from
+
+	for ; n <= s.Version && len(changes) < page.page_size(); n++ {
+
+		bytes, err := stub.GetState(config_history_key(n))
+
+		if err != nil {
+			return nil, errors.New("Unable to get configuration change " + strconv.Itoa(n))
+		}
+
+		var change ConfigChange
+
+		err = json.Unmarshal(bytes, &change)
+
+		if err != nil {
+			return nil, errors.New("Corrupt configuration change record " + string(bytes))
+		}
+
+		changes = append(changes, change)
+	}
+
+	bookmark := ""
+
+	if n <= s.Version {
+		bookmark = strconv.Itoa(n)
+	}
+
+	bytes, err := json.Marshal(struct {
+		Config   Settings       `json:"config"`
+		Changes  []ConfigChange `json:"changes"`
+		Bookmark string         `json:"bookmark"`
+	}{s, changes, bookmark})
+
+	if err != nil {
+		return nil, errors.New("READCONFIG: Error converting configuration")
+	}
+
+	return bytes, nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 TRANSFER_PREFIX - World state keys of pending transfers are TRANSFER_PREFIX followed by the asset ID. An asset has
+//					   at most one pending transfer.
+//==============================================================================================================================
+const TRANSFER_PREFIX = "transfer~"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	TransferRequest - The "transfer" object of the JSON argument of offerTransfer.
+//==============================================================================================================================
+type TransferRequest struct {
+	Recipient     string `json:"recipient"`
+	RecipientRole string `json:"recipientRole"` //only read by the moves of the car lifecycle
+	ExpiresAt     string `json:"expiresAt"`     //RFC3339, optional
+}
+
+//==============================================================================================================================
+//	PendingTransfer - An ownership handover offered by the owner and waiting for the recipient to accept or reject it.
+//==============================================================================================================================
+type PendingTransfer struct {
+	AssetId   string `json:"assetID"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	OfferedAt string `json:"offeredAt"`
+	ExpiresAt string `json:"expiresAt"`
+	TxID      string `json:"txnid"`
+}
+
+//==============================================================================================================================
+//	 expired - Returns true if the transfer carries an expiry that lies before now.
+//==============================================================================================================================
+func (p PendingTransfer) expired(now time.Time) bool {
+
+	if p.ExpiresAt == "" {
+		return false
+	}
+
+	expires, err := time.Parse(time.RFC3339, p.ExpiresAt)
+
+	return err == nil && !now.Before(expires)
+}
+
+//==============================================================================================================================
+//	 retrieve_transfer - Gets the pending transfer of the asset passed. Returns false if there is none.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_transfer(stub shim.ChaincodeStubInterface, v5cID string) (PendingTransfer, bool, error) {
+
+	var p PendingTransfer
+
+	bytes, err := stub.GetState(TRANSFER_PREFIX + v5cID)
+
+	if err != nil {
+		return p, false, errors.New("Unable to get pending transfer of " + v5cID)
+	}
+
+	if bytes == nil {
+		return p, false, nil
+	}
+
+	err = json.Unmarshal(bytes, &p)
+
+	if err != nil {
+		return p, false, errors.New("Corrupt pending transfer record " + string(bytes))
+	}
+
+	return p, true, nil
+}
+
+//==============================================================================================================================
+//	 save_transfer - Writes the pending transfer to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_transfer(stub shim.ChaincodeStubInterface, p PendingTransfer) error {
+
+	bytes, err := json.Marshal(p)
+
+	if err != nil {
+		return errors.New("Error converting pending transfer record")
+	}
+
+	err = stub.PutState(TRANSFER_PREFIX+p.AssetId, bytes)
+
+	if err != nil {
+		return errors.New("Error storing pending transfer record")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 offerTransfer - Records the owner's offer to hand the asset over to the recipient. Ownership does not change until
+//					 the recipient accepts. An expired offer may be replaced, a live one has to be cancelled first.
+//=================================================================================================================================
+func (t *SimpleChaincode) offerTransfer(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, transfer TransferRequest) ([]byte, error) {
+
+	if v.OwnerId != caller {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission denied. offerTransfer caller:"+caller+" is not the owner of "+v.V5cID)
+	}
+
+	if v.asset_type() == ASSET_CAR {
+		return nil, new_error(ERR_INVALID_STATE, "offerTransfer asset "+v.V5cID+" is a car, cars change hands through the moves of their lifecycle, e.g. private_to_private").on_asset(v.V5cID)
+	}
+
+	if transfer.Recipient == "" || transfer.Recipient == v.OwnerId {
+		return nil, new_error(ERR_INVALID_INPUT, "offerTransfer needs a recipient other than the current owner").on_field("transfer.recipient")
+	}
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if transfer.ExpiresAt != "" {
+
+		expires, err := time.Parse(time.RFC3339, transfer.ExpiresAt)
+
+		if err != nil {
+			return nil, new_error(ERR_INVALID_INPUT, "Invalid expiresAt "+transfer.ExpiresAt+", expected RFC3339").on_field("transfer.expiresAt")
+		}
+
+		if !now.Before(expires) {
+			return nil, new_error(ERR_INVALID_INPUT, "offerTransfer expiresAt "+transfer.ExpiresAt+" lies in the past").on_field("transfer.expiresAt")
+		}
+	}
+
+	p, found, err := t.retrieve_transfer(stub, v.V5cID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if found && !p.expired(now) {
+		return nil, new_error(ERR_INVALID_STATE, "Asset "+v.V5cID+" already has a pending transfer to "+p.To)
+	}
+
+	p = PendingTransfer{
+		AssetId:   v.V5cID,
+		From:      v.OwnerId,
+		To:        transfer.Recipient,
+		OfferedAt: now.Format(time.RFC3339),
+		ExpiresAt: transfer.ExpiresAt,
+		TxID:      stub.GetTxID(),
+	}
+
+	err = t.save_transfer(stub, p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.emit_event(stub, EVENT_TRANSFER_OFFERED, v.V5cID, nil, v.OwnerId, transfer.Recipient, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 acceptTransfer - Called by the recipient of a live offer. Makes the recipient the owner and closes the offer.
+//=================================================================================================================================
+func (t *SimpleChaincode) acceptTransfer(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+
+	p, err := t.pending_transfer_for(stub, v, caller, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.expired(now) {
+		return nil, new_error(ERR_INVALID_STATE, "The transfer of "+v.V5cID+" expired at "+p.ExpiresAt)
+	}
+
+	if v.OwnerId != p.From {
+		return nil, new_error(ERR_INVALID_STATE, "The transfer of "+v.V5cID+" was offered by "+p.From+" who no longer owns it")
+	}
+
+	v.OwnerId = p.To
+	v.Caller = caller
+	v.AssetId = v.V5cID
+
+	_, err = t.save_changes(stub, v, caller, "acceptTransfer")
+
+	if err != nil {
+		return nil, wrap_error("Error saving changes", err)
+	}
+
+	err = stub.DelState(TRANSFER_PREFIX + v.V5cID)
+
+	if err != nil {
+		return nil, errors.New("Error removing pending transfer record")
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 rejectTransfer - Called by the recipient to turn an offer down. The asset stays with its owner.
+//=================================================================================================================================
+func (t *SimpleChaincode) rejectTransfer(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+
+	_, err := t.pending_transfer_for(stub, v, caller, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(TRANSFER_PREFIX + v.V5cID)
+
+	if err != nil {
+		return nil, errors.New("Error removing pending transfer record")
+	}
+
+	err = t.emit_event(stub, EVENT_TRANSFER_REJECTED, v.V5cID, nil, v.OwnerId, v.OwnerId, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 cancelTransfer - Called by the owner to withdraw an offer before the recipient has accepted it.
+//=================================================================================================================================
+func (t *SimpleChaincode) cancelTransfer(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+
+	_, err := t.pending_transfer_for(stub, v, caller, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(TRANSFER_PREFIX + v.V5cID)
+
+	if err != nil {
+		return nil, errors.New("Error removing pending transfer record")
+	}
+
+	err = t.emit_event(stub, EVENT_TRANSFER_CANCELLED, v.V5cID, nil, v.OwnerId, v.OwnerId, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//=================================================================================================================================
+//	 readTransfer - Returns the pending transfer of the asset to its owner, its recipient or the regulator.
+//=================================================================================================================================
+func (t *SimpleChaincode) readTransfer(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+
+	p, found, err := t.retrieve_transfer(stub, v.V5cID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, new_error(ERR_NOT_FOUND, "Asset "+v.V5cID+" has no pending transfer")
+	}
+
+	if caller != p.From && caller != p.To && caller_affiliation != REGULATOR {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readTransfer. The caller should be party to the transfer or Regulator.")
+	}
+
+	bytes, err := json.Marshal(p)
+
+	if err != nil {
+		return nil, errors.New("READTRANSFER: Invalid pending transfer object")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 pending_transfer_for - Gets the pending transfer of v and checks the caller is its sender (from is true) or its
+//							recipient (from is false).
+//=================================================================================================================================
+func (t *SimpleChaincode) pending_transfer_for(stub shim.ChaincodeStubInterface, v Vehicle, caller string, from bool) (PendingTransfer, error) {
+
+	p, found, err := t.retrieve_transfer(stub, v.V5cID)
+
+	if err != nil {
+		return p, err
+	}
+
+	if !found {
+		return p, new_error(ERR_NOT_FOUND, "Asset "+v.V5cID+" has no pending transfer")
+	}
+
+	if from && caller != p.From {
+		return p, new_error(ERR_PERMISSION_DENIED, "Permission denied. Only "+p.From+" may cancel the transfer of "+v.V5cID)
+	}
+
+	if !from && caller != p.To {
+		return p, new_error(ERR_PERMISSION_DENIED, "Permission denied. The transfer of "+v.V5cID+" is addressed to "+p.To)
+	}
+
+	return p, nil
+}
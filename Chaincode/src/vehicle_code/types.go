@@ -0,0 +1,600 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 ASSET_TYPE_PREFIX - The definition of asset type <name> is stored at ASSET_TYPE_PREFIX<name>.
+//==============================================================================================================================
+const ASSET_TYPE_PREFIX = "assettype~"
+
+//==============================================================================================================================
+//	 ATTRIBUTE_PREFIX - Put in front of the name of a defined field wherever it is named among the fields of an asset:
+//						in the permission matrix, the history, the changedFields of events and the errors.
+//==============================================================================================================================
+const ATTRIBUTE_PREFIX = "attributes."
+
+//==============================================================================================================================
+//	 Field types - The values a defined field holds. Every value is stored as text: numbers as written, flags as true
+//				   or false and dates as ISO dates.
+//==============================================================================================================================
+const FIELD_STRING = "string"
+const FIELD_NUMBER = "number"
+const FIELD_BOOLEAN = "boolean"
+const FIELD_DATE = "date"
+
+var field_types = []string{FIELD_STRING, FIELD_NUMBER, FIELD_BOOLEAN, FIELD_DATE}
+
+//==============================================================================================================================
+//	 Definition limits - The most fields and transitions a definition may have and the longest value a defined field
+//						 may hold, which is also the limit of fields that set none.
+//==============================================================================================================================
+const MAX_TYPE_FIELDS = 64
+const MAX_TYPE_TRANSITIONS = 64
+const MAX_ATTRIBUTE_LENGTH = 256
+
+//==============================================================================================================================
+//	 Name formats of asset types and their fields
+//==============================================================================================================================
+const PATTERN_TYPE_NAME = "^[a-z][a-z0-9_]{0,31}$"
+const PATTERN_FIELD_NAME = "^[A-Za-z][A-Za-z0-9_]{0,31}$"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	FieldDefinition - One field of an asset type definition. Values must be of Type and their text no longer than
+//					  MaxLength and match Pattern, if set. A Required field must be given when the asset is created.
+//					  Roles (participant codes, see participant_codes), Stages, Owner, OwnerOnly and Once say who may
+//					  write the field afterwards, as the rows of the permission matrix do.
+//==============================================================================================================================
+type FieldDefinition struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Required  bool     `json:"required"`
+	MaxLength int      `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Roles     []string `json:"roles"`
+	Stages    []string `json:"stages,omitempty"`
+	Owner     bool     `json:"owner,omitempty"`
+	OwnerOnly bool     `json:"ownerOnly,omitempty"`
+	Once      bool     `json:"once,omitempty"`
+}
+
+//==============================================================================================================================
+//	AssetTypeDefinition - An asset type registered by the regulator with registerAssetType. A definition named after
+//						  a built in type, truck or car, adds fields to it. Any other name defines a new type: its
+//						  assets start in the first of Statuses and move along Transitions through transitionAsset.
+//						  The roles of the transitions are participant codes, their required fields fields of the
+//						  definition. Version counts the registrations of the type.
+//==============================================================================================================================
+type AssetTypeDefinition struct {
+	Name        string            `json:"name"`
+	Version     int               `json:"version"`
+	Fields      []FieldDefinition `json:"fields"`
+	Statuses    []string          `json:"statuses,omitempty"`
+	Transitions []Transition      `json:"transitions,omitempty"`
+}
+
+//==============================================================================================================================
+//	 is_builtin_type - Returns true for the asset types whose fields and lifecycle are part of the chaincode.
+//==============================================================================================================================
+func is_builtin_type(name string) bool {
+
+	return name == ASSET_TRUCK || name == ASSET_CAR
+}
+
+//==============================================================================================================================
+//	 participant_constants - Returns the participant constants of the participant codes passed, see participant_codes.
+//==============================================================================================================================
+func participant_constants(codes []string) []string {
+
+	roles := []string{}
+
+	for _, code := range codes {
+		roles = append(roles, participant_codes[code])
+	}
+
+	return roles
+}
+
+//==============================================================================================================================
+//	 compiled_pattern - Returns the compiled form of a pattern of a definition. Patterns are compiled once and kept
+//						with those of the payloads, see patterns.
+//==============================================================================================================================
+func compiled_pattern(pattern string) (*regexp.Regexp, error) {
+
+	if compiled := patterns[pattern]; compiled != nil {
+		return compiled, nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	patterns[pattern] = compiled
+
+	return compiled, nil
+}
+
+//==============================================================================================================================
+//	 defined_field - Looks up the field of the definition with the name passed.
+//==============================================================================================================================
+func (d AssetTypeDefinition) defined_field(name string) (FieldDefinition, bool) {
+
+	for _, f := range d.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+
+	return FieldDefinition{}, false
+}
+
+//==============================================================================================================================
+//	 statuses - Returns the statuses assets of the type may be in.
+//==============================================================================================================================
+func (d AssetTypeDefinition) statuses() []string {
+
+	switch d.Name {
+	case ASSET_TRUCK:
+
+		statuses := []string{STATUS_PO_CREATED}
+
+		for _, tr := range transitions {
+			statuses = append(statuses, tr.To)
+		}
+
+		return statuses
+
+	case ASSET_CAR:
+		return append(append([]string{}, car_statuses...), CAR_SCRAPPED)
+	}
+
+	return d.Statuses
+}
+
+//==============================================================================================================================
+//	 lifecycle - Returns the transitions of the type with the roles as participant constants and the required fields
+//				 named as among the fields of an asset, e.g. "attributes.axles".
+//==============================================================================================================================
+func (d AssetTypeDefinition) lifecycle() []Transition {
+
+	switch d.Name {
+	case ASSET_TRUCK:
+		return transitions
+	case ASSET_CAR:
+		return car_lifecycle
+	}
+
+	lifecycle := []Transition{}
+
+	for _, tr := range d.Transitions {
+
+		required := []string{}
+
+		for _, name := range tr.Required {
+			required = append(required, ATTRIBUTE_PREFIX+name)
+		}
+
+		lifecycle = append(lifecycle, Transition{From: tr.From, To: tr.To, Roles: participant_constants(tr.Roles), Required: required})
+	}
+
+	return lifecycle
+}
+
+//==============================================================================================================================
+//	 permission_matrix - Returns the permission matrix of assets of the type: field_permissions and a row for each
+//						 defined field.
+//==============================================================================================================================
+func (d AssetTypeDefinition) permission_matrix() []FieldPermission {
+
+	matrix := append([]FieldPermission{}, field_permissions...)
+
+	for _, f := range d.Fields {
+		matrix = append(matrix, FieldPermission{Field: ATTRIBUTE_PREFIX + f.Name, Roles: participant_constants(f.Roles), Stages: f.Stages, Owner: f.Owner, OwnerOnly: f.OwnerOnly, Once: f.Once})
+	}
+
+	return matrix
+}
+
+//==============================================================================================================================
+//	 check - Checks a definition about to be registered. prefix is put in front of the paths of the errors.
+//==============================================================================================================================
+func (d AssetTypeDefinition) check(prefix string) []FieldError {
+
+	errs := []FieldError{}
+
+	invalid := func(name string, rule string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: prefix + name, Rule: rule, Message: prefix + name + " " + fmt.Sprintf(format, args...)})
+	}
+
+	known_roles := func(name string, roles []string) {
+		for i, role := range roles {
+			if _, ok := participant_codes[role]; !ok {
+				invalid(fmt.Sprintf("%v[%v]", name, i), RULE_PATTERN, "must be one of %v", sorted_keys(participant_codes))
+			}
+		}
+	}
+
+	if is_builtin_type(d.Name) && (len(d.Statuses) > 0 || len(d.Transitions) > 0) {
+		invalid("statuses", RULE_UNKNOWN, "can not be set, the lifecycle of %v assets is part of the chaincode", d.Name)
+	}
+
+	if !is_builtin_type(d.Name) && len(d.Statuses) == 0 {
+		invalid("statuses", RULE_REQUIRED, "must name at least the status %v assets start in", d.Name)
+	}
+
+	for i, status := range d.Statuses {
+		if contains(d.Statuses[:i], status) {
+			invalid(fmt.Sprintf("statuses[%v]", i), ERR_DUPLICATE_KEY, "%v is listed before", status)
+		}
+	}
+
+	statuses := d.statuses()
+
+	for i, f := range d.Fields {
+
+		path := fmt.Sprintf("fields[%v].", i)
+
+		if _, ok := (AssetTypeDefinition{Fields: d.Fields[:i]}).defined_field(f.Name); ok {
+			invalid(path+"name", ERR_DUPLICATE_KEY, "%v is defined before", f.Name)
+		}
+
+		if !contains(field_types, f.Type) {
+			invalid(path+"type", RULE_PATTERN, "must be one of %v", field_types)
+		}
+
+		if f.MaxLength < 0 || f.MaxLength > MAX_ATTRIBUTE_LENGTH {
+			invalid(path+"maxLength", RULE_RANGE, "must be 0 to %v", MAX_ATTRIBUTE_LENGTH)
+		}
+
+		if f.Pattern != "" {
+			if _, err := regexp.Compile(f.Pattern); err != nil {
+				invalid(path+"pattern", RULE_PATTERN, "is not a valid pattern: %v", err)
+			}
+		}
+
+		known_roles(path+"roles", f.Roles)
+
+		for j, stage := range f.Stages {
+			if !contains(statuses, stage) {
+				invalid(fmt.Sprintf("%vstages[%v]", path, j), RULE_UNKNOWN, "%v is not a status of %v assets", stage, d.Name)
+			}
+		}
+	}
+
+	for i, tr := range d.Transitions {
+
+		path := fmt.Sprintf("transitions[%v].", i)
+
+		if !contains(statuses, tr.From) {
+			invalid(path+"from", RULE_UNKNOWN, "%v is not a status of %v assets", tr.From, d.Name)
+		}
+
+		if !contains(statuses, tr.To) {
+			invalid(path+"to", RULE_UNKNOWN, "%v is not a status of %v assets", tr.To, d.Name)
+		}
+
+		if len(tr.Roles) == 0 {
+			invalid(path+"roles", RULE_REQUIRED, "must name who may make the transition")
+		}
+
+		known_roles(path+"roles", tr.Roles)
+
+		for j, name := range tr.Required {
+			if _, ok := d.defined_field(name); !ok {
+				invalid(fmt.Sprintf("%vrequired[%v]", path, j), RULE_UNKNOWN, "%v is not a field of the definition", name)
+			}
+		}
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 attribute_text - Returns the text a value of the defined field passed is stored as, and false if the value is
+//					  not of the field's type.
+//==============================================================================================================================
+func attribute_text(f FieldDefinition, value interface{}) (string, bool) {
+
+	switch value := value.(type) {
+	case string:
+
+		if f.Type == FIELD_DATE {
+
+			if _, ok := parse_date(value); !ok {
+				return value, false
+			}
+
+			return normal_date(value), true
+		}
+
+		return value, f.Type == FIELD_STRING
+
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), f.Type == FIELD_NUMBER
+
+	case bool:
+		return strconv.FormatBool(value), f.Type == FIELD_BOOLEAN
+	}
+
+	return "", false
+}
+
+//==============================================================================================================================
+//	 check_attributes - Checks the "attributes" object of an incoming asset against the definition. When creating,
+//						required fields must be given. prefix is put in front of the paths of the errors.
+//==============================================================================================================================
+func (d AssetTypeDefinition) check_attributes(values map[string]interface{}, prefix string, creating bool) []FieldError {
+
+	errs := []FieldError{}
+
+	for _, name := range sorted_keys(values) {
+		if _, ok := d.defined_field(name); !ok {
+			errs = append(errs, FieldError{Field: prefix + name, Rule: RULE_UNKNOWN, Message: prefix + name + " is not a field of " + d.Name + " assets"})
+		}
+	}
+
+	for _, f := range d.Fields {
+
+		path := prefix + f.Name
+
+		value, given := values[f.Name]
+
+		if !given || value == nil || value == "" {
+
+			if creating && f.Required {
+				errs = append(errs, FieldError{Field: path, Rule: RULE_REQUIRED, Message: path + " is required"})
+			}
+
+			continue
+		}
+
+		text, ok := attribute_text(f, value)
+
+		if !ok {
+			errs = append(errs, FieldError{Field: path, Rule: RULE_TYPE, Message: path + " must be a " + f.Type})
+			continue
+		}
+
+		limit := f.MaxLength
+
+		if limit == 0 {
+			limit = MAX_ATTRIBUTE_LENGTH
+		}
+
+		if len(text) > limit {
+			errs = append(errs, FieldError{Field: path, Rule: RULE_MAX_LENGTH, Message: fmt.Sprintf("%v may be at most %v characters long", path, limit)})
+			continue
+		}
+
+		if f.Pattern == "" {
+			continue
+		}
+
+		if pattern, err := compiled_pattern(f.Pattern); err != nil || !pattern.MatchString(text) {
+			errs = append(errs, FieldError{Field: path, Rule: RULE_PATTERN, Message: fmt.Sprintf("%v does not match %v", path, f.Pattern)})
+		}
+	}
+
+	return errs
+}
+
+//==============================================================================================================================
+//	 attribute_values - Returns the values of the "attributes" object of an incoming asset as stored. Values that are
+//						empty or not of a defined field are left out; check_attributes reports the latter.
+//==============================================================================================================================
+func (d AssetTypeDefinition) attribute_values(values map[string]interface{}) map[string]string {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	stored := map[string]string{}
+
+	for _, f := range d.Fields {
+
+		if text, ok := attribute_text(f, values[f.Name]); ok && text != "" {
+			stored[f.Name] = text
+		}
+	}
+
+	return stored
+}
+
+//==============================================================================================================================
+//	 as_vehicle - As Animal.as_vehicle, with the values of the defined fields.
+//==============================================================================================================================
+func (d AssetTypeDefinition) as_vehicle(a Animal) Vehicle {
+
+	v := a.as_vehicle()
+	v.Attributes = d.attribute_values(a.Attributes)
+
+	return v
+}
+
+//==============================================================================================================================
+//	 new_asset - Returns the asset of a type that is not built in createAsset makes of the request passed. It starts
+//				 in the first status of the definition, owned by its creator unless the request names an owner.
+//==============================================================================================================================
+func (d AssetTypeDefinition) new_asset(v5cID string, caller string, animals Animal) Vehicle {
+
+	v := Vehicle{
+		V5cID:           v5cID,
+		AssetId:         v5cID,
+		AssetType:       d.Name,
+		TransactionType: animals.TransactionType,
+		OwnerId:         animals.OwnerId,
+		Status:          d.Statuses[0],
+	}
+
+	if v.OwnerId == "" {
+		v.OwnerId = caller
+	}
+
+	return v
+}
+
+//==============================================================================================================================
+//	 retrieve_asset_type - Reads the definition of the asset type passed. Returns false if none is registered.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_asset_type(stub shim.ChaincodeStubInterface, name string) (AssetTypeDefinition, bool, error) {
+
+	d := AssetTypeDefinition{Name: name, Fields: []FieldDefinition{}}
+
+	bytes, err := stub.GetState(ASSET_TYPE_PREFIX + name)
+
+	if err != nil {
+		return d, false, new_error(ERR_NOT_FOUND, "Unable to get definition of asset type "+name)
+	}
+
+	if bytes == nil {
+		return d, false, nil
+	}
+
+	err = json.Unmarshal(bytes, &d)
+
+	if err != nil {
+		return d, false, new_error(ERR_INVALID_STATE, "Corrupt definition of asset type "+name)
+	}
+
+	return d, true, nil
+}
+
+//==============================================================================================================================
+//	 asset_definition - Returns the definition assets of the type passed are checked against. Built in types without a
+//						registered definition get an empty one; assets of no type are trucks.
+//==============================================================================================================================
+func (t *SimpleChaincode) asset_definition(stub shim.ChaincodeStubInterface, name string) (AssetTypeDefinition, error) {
+
+	if name == "" {
+		name = ASSET_TRUCK
+	}
+
+	d, registered, err := t.retrieve_asset_type(stub, name)
+
+	if err != nil {
+		return d, err
+	}
+
+	if !registered && !is_builtin_type(name) {
+		return d, new_error(ERR_INVALID_INPUT, "Unknown asset type "+name+", the regulator registers asset types with registerAssetType").on_field("asset.assetType")
+	}
+
+	return d, nil
+}
+
+//=================================================================================================================================
+//	 registerAssetType - Registers the asset type definition passed, or replaces the one registered under its name,
+//						 and returns it. Assets already stored keep their values; a changed definition applies to
+//						 what is written from then on. Statuses may only be appended, as stored assets may be in any
+//						 status registered before. Only the admins of the configuration may register types.
+//=================================================================================================================================
+func (t *SimpleChaincode) registerAssetType(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, d AssetTypeDefinition) ([]byte, error) {
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.may_configure(caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. registerAssetType. Only the admins "+strings.Join(settings.Admins, ", ")+" may register asset types")
+	}
+
+	if errs := d.check("definition."); len(errs) > 0 {
+		return nil, invalid_input("registerAssetType", "the definition is invalid", errs)
+	}
+
+	before, _, err := t.retrieve_asset_type(stub, d.Name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	errs := []FieldError{}
+
+	for i, status := range before.Statuses {
+		if i >= len(d.Statuses) || d.Statuses[i] != status {
+			field := fmt.Sprintf("definition.statuses[%v]", i)
+			errs = append(errs, FieldError{Field: field, Rule: RULE_REQUIRED, Message: field + " must stay " + status + ", registered statuses can not be removed, renamed or moved"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, invalid_input("registerAssetType", "the definition drops statuses of "+d.Name+" assets", errs)
+	}
+
+	d.Version = before.Version + 1
+
+	if d.Fields == nil {
+		d.Fields = []FieldDefinition{}
+	}
+
+	for i := range d.Fields {
+		if d.Fields[i].Roles == nil {
+			d.Fields[i].Roles = []string{}
+		}
+	}
+
+	bytes, err := json.Marshal(d)
+
+	if err != nil {
+		return nil, errors.New("REGISTERASSETTYPE: Error converting definition")
+	}
+
+	err = stub.PutState(ASSET_TYPE_PREFIX+d.Name, bytes)
+
+	if err != nil {
+		return nil, errors.New("REGISTERASSETTYPE: Error storing definition")
+	}
+
+	return bytes, nil
+}
+
+//=================================================================================================================================
+//	 readAssetTypes - Returns the registered asset type definitions, by name.
+//=================================================================================================================================
+func (t *SimpleChaincode) readAssetTypes(stub shim.ChaincodeStubInterface) ([]byte, error) {
+
+	entries, err := range_entries(stub, ASSET_TYPE_PREFIX)
+
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := []AssetTypeDefinition{}
+
+	for _, e := range entries {
+
+		var d AssetTypeDefinition
+
+		err = json.Unmarshal([]byte(e.Value), &d)
+
+		if err != nil {
+			return nil, new_error(ERR_INVALID_STATE, "Corrupt definition of asset type "+e.Key)
+		}
+
+		definitions = append(definitions, d)
+	}
+
+	bytes, err := json.Marshal(struct {
+		AssetTypes []AssetTypeDefinition `json:"assetTypes"`
+	}{definitions})
+
+	if err != nil {
+		return nil, errors.New("READASSETTYPES: Error converting definitions")
+	}
+
+	return bytes, nil
+}
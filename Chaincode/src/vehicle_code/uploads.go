@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Upload keys - An upload in progress is stored at UPLOAD_PREFIX<assetID>~<uploadId>, chunk n of it at
+//				   UPLOAD_CHUNK_PREFIX<assetID>~<uploadId>~<n, zero padded>. Committing the upload turns the chunks into
+//				   the content of a document; until then readers never see them.
+//==============================================================================================================================
+const UPLOAD_PREFIX = "upload~"
+const UPLOAD_CHUNK_PREFIX = "uploadchunk~"
+
+//==============================================================================================================================
+//	 Structure Definitions
+//==============================================================================================================================
+//	Upload - A document being uploaded in chunks. Only its uploader may add chunks to it, commit or abort it.
+//==============================================================================================================================
+type Upload struct {
+	UploadId     string `json:"uploadId"`
+	AssetId      string `json:"assetID"`
+	DocType      string `json:"docType"`
+	FileName     string `json:"fileName"`
+	MimeType     string `json:"mimeType"`
+	Hash         string `json:"sha256"`
+	ChunkSizes   []int  `json:"chunkSizes"`
+	Uploader     string `json:"uploader"`
+	UploaderRole string `json:"uploaderRole"`
+	StartedAt    string `json:"startedAt"`
+}
+
+//==============================================================================================================================
+//	 chunk_key - Returns the key of chunk n of the upload passed.
+//==============================================================================================================================
+func chunk_key(v5cID string, uploadId string, n int) string {
+
+	return fmt.Sprintf("%v%v~%v~%06d", UPLOAD_CHUNK_PREFIX, v5cID, uploadId, n)
+}
+
+//==============================================================================================================================
+//	 retrieve_upload - Gets the upload passed and checks the caller started it.
+//==============================================================================================================================
+func (t *SimpleChaincode) retrieve_upload(stub shim.ChaincodeStubInterface, v5cID string, uploadId string, caller string) (Upload, error) {
+
+	var u Upload
+
+	bytes, err := stub.GetState(doc_key(UPLOAD_PREFIX, v5cID, uploadId))
+
+	if err != nil {
+		return u, errors.New("Unable to get upload " + uploadId + " of " + v5cID)
+	}
+
+	if bytes == nil {
+		return u, new_error(ERR_NOT_FOUND, "Asset "+v5cID+" has no upload "+uploadId+" in progress").on_field("doc.uploadId")
+	}
+
+	err = json.Unmarshal(bytes, &u)
+
+	if err != nil {
+		return u, errors.New("Corrupt upload record " + string(bytes))
+	}
+
+	if u.Uploader != caller {
+		return u, new_error(ERR_PERMISSION_DENIED, "Permission denied. Upload "+uploadId+" was started by "+u.Uploader)
+	}
+
+	return u, nil
+}
+
+//==============================================================================================================================
+//	 save_upload - Writes the upload record to the ledger.
+//==============================================================================================================================
+func (t *SimpleChaincode) save_upload(stub shim.ChaincodeStubInterface, u Upload) error {
+
+	bytes, err := json.Marshal(u)
+
+	if err != nil {
+		return errors.New("Error converting upload record")
+	}
+
+	err = stub.PutState(doc_key(UPLOAD_PREFIX, u.AssetId, u.UploadId), bytes)
+
+	if err != nil {
+		return errors.New("Error storing upload record")
+	}
+
+	return nil
+}
+
+//=================================================================================================================================
+//	 beginUpload - Starts a chunked upload of a document to the asset. Returns the upload ID the chunks are sent to.
+//				   The expected SHA-256 may be passed now or at commitUpload.
+//=================================================================================================================================
+func (t *SimpleChaincode) beginUpload(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	if _, ok := find_doc_type(doc.DocType); !ok {
+		return nil, new_error(ERR_INVALID_INPUT, fmt.Sprintf("Invalid docType %v, expected one of %v, %v, %v", doc.DocType, DOC_DELIVERY_CERTIFICATE, DOC_TEST_REPORT, DOC_INVOICE)).on_field("doc.docType")
+	}
+
+	if !may_upload(doc.DocType, v, caller, caller_affiliation) {
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. beginUpload caller:%v role:%v may not upload documents of type %v", caller, caller_affiliation, doc.DocType))
+	}
+
+	now, err := t.tx_time(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	u := Upload{
+		UploadId:     stub.GetTxID(),
+		AssetId:      v.V5cID,
+		DocType:      doc.DocType,
+		FileName:     doc.FileName,
+		MimeType:     doc.MimeType,
+		Hash:         strings.ToLower(doc.Hash),
+		ChunkSizes:   []int{},
+		Uploader:     caller,
+		UploaderRole: caller_affiliation,
+		StartedAt:    now.Format(time.RFC3339),
+	}
+
+	err = t.save_upload(stub, u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(u)
+}
+
+//=================================================================================================================================
+//	 appendChunk - Adds the next chunk, base64 encoded and at most maxDocSize characters, to an upload.
+//=================================================================================================================================
+func (t *SimpleChaincode) appendChunk(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	u, err := t.retrieve_upload(stub, v.V5cID, doc.UploadId, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := t.retrieve_settings(stub)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) > settings.MaxDocSize {
+		return nil, new_error(ERR_TOO_LARGE, fmt.Sprintf("A chunk cannot be larger than %v characters", settings.MaxDocSize)).on_field("doc.content")
+	}
+
+	chunk, _, err := decode_content(doc.Content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunk) == 0 {
+		return nil, new_error(ERR_INVALID_INPUT, "A chunk cannot be empty").on_field("doc.content")
+	}
+
+	err = stub.PutState(chunk_key(u.AssetId, u.UploadId, len(u.ChunkSizes)), chunk)
+
+	if err != nil {
+		return nil, errors.New("Error storing chunk")
+	}
+
+	u.ChunkSizes = append(u.ChunkSizes, len(chunk))
+
+	err = t.save_upload(stub, u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(u)
+}
+
+//=================================================================================================================================
+//	 commitUpload - Checks the chunks of an upload against the expected SHA-256 and turns them into a document.
+//=================================================================================================================================
+func (t *SimpleChaincode) commitUpload(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	u, err := t.retrieve_upload(stub, v.V5cID, doc.UploadId, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Hash != "" {
+		u.Hash = strings.ToLower(doc.Hash)
+	}
+
+	if u.Hash == "" {
+		return nil, new_error(ERR_INVALID_INPUT, "commitUpload needs the sha256 of the document, passed here or to beginUpload").on_field("doc.sha256")
+	}
+
+	if len(u.ChunkSizes) == 0 {
+		return nil, new_error(ERR_INVALID_STATE, "Upload "+u.UploadId+" has no chunks").on_field("doc.uploadId")
+	}
+
+	hash := sha256.New()
+	size := 0
+
+	for i := range u.ChunkSizes {
+
+		chunk, err := stub.GetState(chunk_key(u.AssetId, u.UploadId, i))
+
+		if err != nil || chunk == nil {
+			return nil, errors.New(fmt.Sprintf("Unable to get chunk %v of upload %v", i, u.UploadId))
+		}
+
+		hash.Write(chunk)
+		size += len(chunk)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	if sum != u.Hash {
+		return nil, new_error(ERR_HASH_MISMATCH, "Upload "+u.UploadId+" has sha256 "+sum+", expected "+u.Hash).on_field("doc.sha256")
+	}
+
+	d, err := t.new_doc(stub, v, caller, caller_affiliation, DocRequest{DocType: u.DocType, FileName: u.FileName, MimeType: u.MimeType}, sum, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.UploadId = u.UploadId
+	d.ChunkSizes = u.ChunkSizes
+
+	err = t.record_doc(stub, v, d, caller, "commitUpload")
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.DelState(doc_key(UPLOAD_PREFIX, u.AssetId, u.UploadId))
+
+	if err != nil {
+		return nil, errors.New("Error removing upload record")
+	}
+
+	return json.Marshal(d)
+}
+
+//=================================================================================================================================
+//	 abortUpload - Drops an upload and its chunks.
+//=================================================================================================================================
+func (t *SimpleChaincode) abortUpload(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, doc DocRequest) ([]byte, error) {
+
+	u, err := t.retrieve_upload(stub, v.V5cID, doc.UploadId, caller)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range u.ChunkSizes {
+
+		err = stub.DelState(chunk_key(u.AssetId, u.UploadId, i))
+
+		if err != nil {
+			return nil, errors.New("Error removing chunk")
+		}
+	}
+
+	err = stub.DelState(doc_key(UPLOAD_PREFIX, u.AssetId, u.UploadId))
+
+	if err != nil {
+		return nil, errors.New("Error removing upload record")
+	}
+
+	return nil, nil
+}
@@ -3,15 +3,15 @@ package main
 import (
 	"errors"
 	"fmt"
+	"os"
 	"time"
-	"bytes"
 	"unicode/utf8"
 
 //	"strconv"
-	"strings"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"encoding/json"
 	"regexp"
+	"strings"
 )
 
 var logger = shim.NewLogger("CLDChaincode")
@@ -36,7 +36,8 @@ const   SCRAP_MERCHANT =  "scrap_merchant"
 
 //==============================================================================================================================
 //	 Status types - Asset lifecycle is broken down into 5 statuses, this is part of the business logic to determine what can
-//					be done to the vehicle at points in it's lifecycle
+//					be done to the vehicle at points in it's lifecycle. Car records of the legacy schema hold these numbers,
+//					see car_statuses for the statuses they stand for now.
 //==============================================================================================================================
 const   STATE_TEMPLATE  			=  0
 const   STATE_MANUFACTURE  			=  1
@@ -99,6 +100,16 @@ type Vehicle struct {
 		AfDoc				string	`json:"afDoc"`
 		Caller				string  `json:"caller"`
 		V5cID           string `json:"v5cID"`
+		Status				string	`json:"status"`			//lifecycle status, see lifecycle.go for trucks and cars.go for cars
+		AssetType			string	`json:"assetType"`		//truck or car, empty on records written before cars came back
+		Make				string	`json:"make,omitempty"`	//the car fields, see cars.go
+		Model				string	`json:"model,omitempty"`
+		Reg					string	`json:"reg,omitempty"`
+		VIN					string	`json:"VIN,omitempty"`
+		Colour				string	`json:"colour,omitempty"`
+		LeaseContractID		string	`json:"leaseContractID,omitempty"`
+		Scrapped			bool	`json:"scrapped,omitempty"`
+		Attributes			map[string]string	`json:"attributes,omitempty"`	//the fields of the asset type definition, see types.go
 
 		}  
 
@@ -130,42 +141,35 @@ type Animal struct {
 		AfDoc				string	`json:"afDoc"`
 		Caller				string  `json:"caller"`		//the UI/person who fired the transaction
 		V5cid           string `json:"v5cID"`
+		Status				string	`json:"status"`		//target status, only read by transitionAsset
+		AssetType			string	`json:"assetType"`		//truck or car, only read on creation
+		Make				string	`json:"make"`
+		Model				string	`json:"model"`
+		Reg					string	`json:"reg"`
+		VIN					string	`json:"VIN"`
+		Colour				string	`json:"colour"`
+		Attributes			map[string]interface{}	`json:"attributes"`	//checked against the asset type definition, see types.go
 
 		}
 
 
 /* momentary structure to hol input json*/
 type InRequest struct {
-		Asset struct {	
-		
-		TransactionType 	string	`json:"transactionType"`
-		OwnerId				string	`json:"ownerId"`
-		AssetId				string	`json:"assetID"`		//BLOCKCHAIN KEY
-															//NOTE: assetId changed to assetID based on request from UI developer
-		MatnrAf				string	`json:"matnrAf"`
-		PoDma				string	`json:"poDma"`
-		PoSupp				string	`json:"poSupp"`
-		DmaDelDate			string	`json:"dmaDelDate"`
-		AfDelDate			string	`json:"afDelDate"`
-		TruckMod			string	`json:"truckMod"`
-		TruckPDate			string	`json:"truckPdate"`
-		TruckChnum			string	`json:"truckChnum"`
-		TruckEnnum			string	`json:"truckEnnum"`
-		SuppTest			string	`json:"suppTest"`
-		GrDma				string	`json:"grDma"`
-		GrAf				string	`json:"grAf"`
-		DmaMasdat			string	`json:"dmaMasdat"`
-		AfDmaTest			string	`json:"afDmaTest"`
-		DmaDelCert			string	`json:"dmaDelCert"`
-		AfDoc				string	`json:"afDoc"`
-		Caller				string  `json:"caller"`		//the UI/person who fired the transaction
-		V5cid           string `json:"v5cID"`
-		} 
+		Asset				Animal			`json:"asset"`
+		Transfer			TransferRequest	`json:"transfer"`	//only read by offerTransfer
+		Page				PageRequest		`json:"page"`		//only read by paged queries
+		Filter				AssetFilter		`json:"filter"`		//only read by readAllAssets
+		Doc					DocRequest		`json:"doc"`			//only read by the document functions
+		Options				ResponseOptions	`json:"options"`		//only read by queries returning assets
+		Assets				[]BatchItem		`json:"assets"`		//only read by createAssets
+		Updates				[]ExternalUpdate	`json:"updates"`		//only read by applyExternalUpdates
+		Config				json.RawMessage		`json:"config"`		//only read by updateConfig
+		Definition			AssetTypeDefinition	`json:"definition"`	//only read by registerAssetType
 }
 		
 //==============================================================================================================================
-//	V5C Holder - Defines the structure that holds all the v5cIDs for vehicles that have been created.
-//				Used as an index when querying all vehicles.
+//	V5C Holder - Defines the structure that held all the v5cIDs for vehicles that have been created.
+//				Replaced by per asset index keys, only read by migrateAssetIndex.
 //==============================================================================================================================
 
 type V5C_Holder struct {
@@ -178,30 +182,66 @@ type V5C_Holder struct {
 
 type User_and_eCert struct {
 	Identity string `json:"identity"`
-	eCert string `json:"ecert"`
+	ECert string `json:"ecert"`
 }
 
 //==============================================================================================================================
 //	Init Function - Called when the user deploys the chaincode
 //==============================================================================================================================
-func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) (result []byte, err error) {
+
+	defer func() { if err != nil { err = as_chaincode_error(err, "") } }()
 
 	//Args
-	//				0
-	//			peer_address
+	//				0			1
+	//			name		value		(repeated)
+	//
+	//	Pairs whose name is a setting (e.g. identityMode) configure the deployment, all other pairs are users and eCerts.
+	//	Settings holding objects or lists (e.g. roles) are passed as JSON text.
+	//
+	//	Init is also called when the chaincode is redeployed over an existing ledger. The settings recorded are then
+	//	kept and may only be changed by the regulator through updateConfig, so setting pairs are refused. A ledger
+	//	written in an earlier schema is left as it is until the regulator runs migrate.
+
+	if len(args) % 2 != 0 { return nil, new_error(ERR_INVALID_INPUT, "Init expects name/value pairs, got an odd number of arguments") }
+
+	before, err := t.retrieve_settings(stub)
+
+	if err != nil { return nil, err }
 
-	var v5cIDs V5C_Holder
+	settings := default_settings()
 
-	bytes, err := json.Marshal(v5cIDs)
+	if before.Version > 0 { settings = before.copy() }		// a redeploy keeps the configuration
 
-    if err != nil { return nil, errors.New("Error creating V5C_Holder record") }
+	if before.Version == 0 { before = Settings{} }		// nothing recorded yet, the first change lists every setting
 
-	err = stub.PutState("v5cIDs", bytes)
+	err = t.init_schema(stub, before.Version > 0)		// existing assets and indexes are never rewritten here, see migrate
+
+	if err != nil { return nil, err }
 
 	for i:=0; i < len(args); i=i+2 {
-		t.add_ecert(stub, args[i], args[i+1])
+
+		is_setting, err := settings.apply_setting(args[i], args[i+1])
+
+		if err != nil { return nil, err }
+
+		if is_setting && before.Version > 0 { return nil, new_error(ERR_PERMISSION_DENIED, "Init can not change setting " + args[i] + " of a configured ledger, the regulator changes settings with updateConfig") }
+
+		if is_setting { continue }
+
+		_, err = t.add_ecert(stub, args[i], args[i+1])
+
+		if err != nil { return nil, err }
 	}
 
+	if errs := settings.check(""); len(errs) > 0 { return nil, invalid_input("Init", "invalid settings", errs) }
+
+	deployer, _ := t.get_username(stub)
+
+	_, err = t.save_settings(stub, before, settings, deployer, "Init")
+
+	if err != nil { return nil, err }
+
 	return nil, nil
 }
 
@@ -229,7 +269,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 
 	err := stub.PutState(name, []byte(ecert))
 
-	if err == nil {
+	if err != nil {
 		return nil, errors.New("Error storing eCert for user " + name + " identity: " + ecert)
 	}
 
@@ -245,7 +285,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string, error) {
 
     username, err := stub.ReadCertAttribute("username");
-	if err != nil { return "", errors.New("Couldn't get attribute 'username'. Error: " + err.Error()) }
+	if err != nil { return "", new_error(ERR_UNAUTHENTICATED, "Couldn't get attribute 'username'. Error: " + err.Error()) }
 	return string(username), nil
 }
 
@@ -256,7 +296,7 @@ func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string
 
 func (t *SimpleChaincode) check_affiliation(stub shim.ChaincodeStubInterface) (string, error) {
     affiliation, err := stub.ReadCertAttribute("role");
-	if err != nil { return "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error()) }
+	if err != nil { return "", new_error(ERR_UNAUTHENTICATED, "Couldn't get attribute 'role'. Error: " + err.Error()) }
 	return string(affiliation), nil
 
 }
@@ -283,6 +323,19 @@ func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (str
 	return user, affiliation, nil
 }
 
+//==============================================================================================================================
+//	 tx_time - Returns the timestamp of the current transaction as a time.Time.
+//==============================================================================================================================
+
+func (t *SimpleChaincode) tx_time(stub shim.ChaincodeStubInterface) (time.Time, error) {
+
+	txntmsp, err := stub.GetTxTimestamp()
+
+	if err != nil || txntmsp == nil { return time.Time{}, errors.New("Couldn't get transaction timestamp") }
+
+	return time.Unix(txntmsp.Seconds, int64(txntmsp.Nanos)).UTC(), nil
+}
+
 //==============================================================================================================================
 //	 retrieve_v5c - Gets the state of the data at v5cID in the ledger then converts it from the stored
 //					JSON into the Vehicle struct for use in the contract. Returns the Vehcile struct.
@@ -294,186 +347,99 @@ func (t *SimpleChaincode) retrieve_v5c(stub shim.ChaincodeStubInterface, v5cID s
 
 	bytes, err := stub.GetState(v5cID);
 
-	if err != nil {	fmt.Printf("RETRIEVE_V5C: Failed to invoke vehicle_code: %s", err); return v, errors.New("RETRIEVE_V5C: Error retrieving vehicle with v5cID = " + v5cID) }
+	if err != nil {	return v, errors.New("RETRIEVE_V5C: Error retrieving vehicle with v5cID = " + v5cID) }
+
+	if bytes == nil { return v, new_error(ERR_NOT_FOUND, "RETRIEVE_V5C: No vehicle with v5cID = " + v5cID).on_asset(v5cID) }
+
+	if _, legacy := legacy_vehicle(bytes); legacy { return v, new_error(ERR_INVALID_STATE, "RETRIEVE_V5C: Vehicle " + v5cID + " is a car record of an earlier schema, the regulator has to run migrate").on_asset(v5cID) }
 
 	err = json.Unmarshal(bytes, &v);
 
-    if err != nil {	fmt.Printf("RETRIEVE_V5C: Corrupt vehicle record "+string(bytes)+": %s", err); return v, errors.New("RETRIEVE_V5C: Corrupt vehicle record"+string(bytes))	}
+    if err != nil {	return v, new_error(ERR_INVALID_STATE, "RETRIEVE_V5C: Corrupt vehicle record " + v5cID).on_asset(v5cID)	}
 
 	return v, nil
 }
 
 //==============================================================================================================================
-// save_changes - Writes to the ledger the Vehicle struct passed in a JSON format. Uses the shim file's
-//				  method 'PutState'.
+// save_changes - Writes to the ledger the Vehicle struct passed in a JSON format, see store_changes, and announces
+//				  the change in a chaincode event.
 //==============================================================================================================================
-func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, v Vehicle) (bool, error) {
+func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, v Vehicle, caller string, function string) (bool, error) {
 
-	bytes, err := json.Marshal(v)
+	before, changes, err := t.store_changes(stub, v, caller, function, "")
 
-	if err != nil { fmt.Printf("SAVE_CHANGES: Error converting vehicle record: %s", err); return false, errors.New("Error converting vehicle record") }
+	if err != nil { return false, err }
 
-	err = stub.PutState(v.V5cID, bytes)
+	err = t.emit_event(stub, event_type(function), v.V5cID, changes, before.OwnerId, v.OwnerId, caller)
 
-	if err != nil { fmt.Printf("SAVE_CHANGES: Error storing vehicle record: %s", err); return false, errors.New("Error storing vehicle record") }
+	if err != nil { return false, err }
 
 	return true, nil
 }
 
-
-
 //==============================================================================================================================
-//	 Router Functions
-//==============================================================================================================================
-//	Invoke - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
-//		  initial arguments passed to other things for use in the called function e.g. name -> ecert
+// store_changes - Writes to the ledger the Vehicle struct passed in a JSON format. Uses the shim file's
+//				   method 'PutState'. Business key lookups are moved along and the fields changed since the stored
+//				   record are appended to the asset's history together with the caller and the chaincode function
+//				   making the change and, for changes made on behalf of an external system, the ID of the message
+//				   that asked for them. Returns the stored record and the changes; sending the event is up to the caller.
 //==============================================================================================================================
-func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) store_changes(stub shim.ChaincodeStubInterface, v Vehicle, caller string, function string, message_id string) (Vehicle, []FieldChange, error) {
+
+	var before Vehicle
 
-	caller, caller_affiliation, err := t.get_caller_data(stub)
-
-	//if err != nil { return nil, errors.New("Error retrieving caller information")}
-    if err != nil { fmt.Printf("Error retrieving caller information")}
-
-    
-    // Get the args from the transaction proposal
-    Args := stub.GetStringArgs()
-    //byte1,err1:= stub.GetArgsSlice()
-    
-    //byte1:= stub.GetArgs()
-    
-    //if (err1 != nil) {fmt.Printf("No error")}
-     // if len(args) != 2 {
-     //   return nil("Incorrect arguments. Expecting a key and a value")
-      //}
-    
-    var animals Animal
-    var inreq InRequest
-	//err2 := json.Unmarshal([]byte(Args[1]), &animals)
-	err2 := json.Unmarshal([]byte(Args[1]), &inreq)
-	if err2 != nil {
-		fmt.Println("error:", err2)
+	bytes, err := stub.GetState(v.V5cID)
+
+	if err != nil { return before, nil, errors.New("Error retrieving vehicle record") }
+
+	if bytes != nil {
+
+		err = json.Unmarshal(bytes, &before)
+
+		if err != nil { return before, nil, errors.New("Corrupt vehicle record "+string(bytes)) }
 	}
-    
-	//Now assign the real arguments to object animal 
-	animals = inreq.Asset
-	
-	//return nil, errors.New("INPUT REQUEST:" + Args[1]+ "animals struct :"+ animals.AssetId + ":" + animals.Caller + ":" )
-	// IMPORTANT: v5cid variable is used in most of the places in this contract
-	// the frontend will pass the field assetID as the
-	// copy assetID over to v5cid here
-	animals.V5cid = animals.AssetId
-	
-    //fmt.Println("Input Arguments are: %v", animals)
-    //return nil, errors.New("animals struct :"+ animals.V5cid + ":" + animals.Make + ":")
-
-	if function == "create_vehicle" {
-        return t.create_vehicle(stub, "DVLA", AUTHORITY, animals.V5cid)
-	} else if function == "createAsset" {
-		return t.createAsset(stub, "DVLA", AUTHORITY, animals.V5cid, animals)
-    } else if function == "ping" {
-        return t.ping(stub)
-    } else { 																				// If the function is not a create then there must be a *** so we need to retrieve the ***.
-		//argPos := 1
-		
 
-		if function == "scrap_vehicle" {																// If its a scrap vehicle then only two arguments are passed (no update value) all others have three arguments and the v5cID is expected in the last argument
-			//argPos = 0
-			fmt.Printf("scrap vehicle")
-		}
+	err = t.update_lookups(stub, before, v)
 
-		//v, err := t.retrieve_v5c(stub, args[argPos])
-		v, err := t.retrieve_v5c(stub, animals.V5cid)
+	if err != nil { return before, nil, err }
 
-        if err != nil { fmt.Printf("INVOKE: Error retrieving v5c: %s", err); return nil, errors.New("Error retrieving v5c") }
+	bytes, err = json.Marshal(v)
 
+	if err != nil { return before, nil, errors.New("Error converting vehicle record") }
 
-        if strings.Contains(function, "update") == true {
+	err = stub.PutState(v.V5cID, bytes)
 
+	if err != nil { return before, nil, errors.New("Error storing vehicle record") }
 
-				/*if 		   function == "authority_to_manufacturer" { return t.authority_to_manufacturer(stub, v, caller, caller_affiliation, args[0], "manufacturer")
-				} else if  function == "manufacturer_to_private"   { return t.manufacturer_to_private(stub, v, caller, caller_affiliation, args[0], "private")
-				} else if  function == "private_to_private" 	   { return t.private_to_private(stub, v, caller, caller_affiliation, args[0], "private")
-				} else if  function == "private_to_lease_company"  { return t.private_to_lease_company(stub, v, caller, caller_affiliation, args[0], "lease_company")
-				} else if  function == "lease_company_to_private"  { return t.lease_company_to_private(stub, v, caller, caller_affiliation, args[0], "private")
-				} else if  function == "private_to_scrap_merchant" { return t.private_to_scrap_merchant(stub, v, caller, caller_affiliation, args[0], "scrap_merchant")
-				}
+	changes := field_changes(before, v)
 
-		} else if function == "update_make"  	    { return t.update_make(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "update_model"        { return t.update_model(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "update_reg" { return t.update_registration(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "update_vin" 			{ return t.update_vin(stub, v, caller, caller_affiliation, args[0])
-        } else if function == "update_colour" 		{ return t.update_colour(stub, v, caller, caller_affiliation, args[0])
-		} else if function == "scrap_vehicle" 		{ return t.scrap_vehicle(stub, v, caller, caller_affiliation) 
-		} else*/ 
-				//if function == "updateAsset" 		{ return t.updateAsset(stub, v, caller, caller_affiliation,"dummy new value",animals) 
-		if function == "updateAsset" 		{ return t.updateAsset(stub, v, animals.Caller, caller_affiliation,"dummy new value",animals) 
-				} else if function == "updateDoc" 		{ return t.updateDoc(stub, v, caller, caller_affiliation,animals) }
-		
-	}
-	}
-	return nil, errors.New("Function of the name "+ function +" doesn't exist.")
+	err = t.append_history(stub, v.V5cID, changes, caller, function, message_id)
+
+	if err != nil { return before, nil, err }
 
+	return before, changes, nil
 }
-//=================================================================================================================================
-//	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
-//  		initial arguments passed are passed on to the called function.
 
 
-//=================================================================================================================================
-func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	caller, caller_affiliation, err := t.get_caller_data(stub)
-	//if err != nil { fmt.Printf("QUERY: Error retrieving caller details", err); return nil, errors.New("QUERY: Error retrieving caller details: "+err.Error()) }
-    if err != nil { fmt.Printf("Error retrieving caller information")}
-    logger.Debug("function: ", function)
-    logger.Debug("caller: ", caller)
-    logger.Debug("affiliation: ", caller_affiliation)
-    // Get the args from the transaction proposal
-    Args := stub.GetStringArgs()
-    var animals Animal
-    var inreq InRequest
-	//err2 := json.Unmarshal([]byte(Args[1]), &animals)
-	err2 := json.Unmarshal([]byte(Args[1]), &inreq)
-	if err2 != nil {
-		fmt.Println("error:", err2)
-	}
-	
-	
-	animals = inreq.Asset
-    
-	// IMPORTANT: v5cid variable is used in most of the places in this contract
-	// the frontend will pass the field assetID as the
-	// copy assetID over to v5cid here
-	animals.V5cid = animals.AssetId
-	
-	caller = animals.Caller
-    caller_affiliation = AUTHORITY
-    
-    
-
-	if function == "get_vehicle_details" || function == "readAsset"  {
-		if len(args) != 1 { fmt.Printf("Incorrect number of arguments passed"); return nil, errors.New("QUERY: Incorrect number of arguments passed") }
-		v, err := t.retrieve_v5c(stub, animals.V5cid)
-		if err != nil { fmt.Printf("QUERY: Error retrieving v5c: %s", err); return nil, errors.New("QUERY: Error retrieving v5c "+err.Error())}
-		return t.get_vehicle_details(stub, v, caller, caller_affiliation)
-		 
-	} else if function == "check_unique_v5c" {
-		return t.check_unique_v5c(stub, animals.V5cid, caller, caller_affiliation)
-	} else if function == "get_vehicles" || function == "readAllAssets" {
-		return t.get_vehicles(stub, caller, caller_affiliation)
-	} else if function == "get_ecert" {
-		return t.get_ecert(stub,animals.V5cid)
-	} else if function == "ping" {
-		return t.ping(stub)
-	} else if function == "readDoc" {
-		 v, err := t.retrieve_v5c(stub, animals.V5cid)
-		 _ = err
-		 return t.readDoc(stub, v, caller, caller_affiliation) 
-	}
+//==============================================================================================================================
+//	 Router Functions
+//==============================================================================================================================
+//	Invoke - Called on chaincode invoke. Looks the function name passed up in the function registry and hands the
+//		  parsed request to its handler.
+//==============================================================================================================================
+func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	return nil, errors.New("Received unknown function invocation " + function)
+	return t.route(stub, INVOKE, function, args)
+}
 
+//=================================================================================================================================
+//	Query - Called on chaincode query. Looks the function name passed up in the function registry and hands the
+//  		parsed request to its handler.
+//=================================================================================================================================
+func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	return t.route(stub, QUERY, function, args)
 }
 
 //=================================================================================================================================
@@ -491,688 +457,292 @@ func (t *SimpleChaincode) ping(stub shim.ChaincodeStubInterface) ([]byte, error)
 //	 Create Vehicle - Creates the initial JSON for the vehcile and then saves it to the ledger.
 //=================================================================================================================================
 func (t *SimpleChaincode) create_vehicle(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, v5cID string) ([]byte, error) {
-	var v Vehicle
 
-	v5c_ID         := "\"v5cID\":\""+v5cID+"\", "							// Variables to define the JSON
-	vin            := "\"VIN\":0, "
-	make           := "\"Make\":\"UNDEFINED\", "
-	model          := "\"Model\":\"UNDEFINED\", "
-	reg            := "\"Reg\":\"UNDEFINED\", "
-	owner          := "\"Owner\":\""+caller+"\", "
-	colour         := "\"Colour\":\"UNDEFINED\", "
-	leaseContract  := "\"LeaseContractID\":\"UNDEFINED\", "
-	status         := "\"Status\":0, "
-	scrapped       := "\"Scrapped\":false"
+	v := Vehicle{V5cID: v5cID, AssetId: v5cID, AssetType: ASSET_CAR, OwnerId: caller, Status: CAR_TEMPLATE}	// a car template, owned by the regulator until it goes to a manufacturer
 
-	vehicle_json := "{"+v5c_ID+vin+make+model+reg+owner+colour+leaseContract+status+scrapped+"}" 	// Concatenates the variables to create the total JSON object
+	matched, err := regexp.Match("^[A-z][A-z][0-9]{7}$", []byte(v5cID))  				// matched = true if the v5cID passed fits format of two letters followed by seven digits
 
-	matched, err := regexp.Match("^[A-z][A-z][0-9]{7}", []byte(v5cID))  				// matched = true if the v5cID passed fits format of two letters followed by seven digits
+												if err != nil { return nil, new_error(ERR_INVALID_INPUT, "Invalid v5cID").on_field("asset.assetID") }
 
-												if err != nil { fmt.Printf("CREATE_VEHICLE: Invalid v5cID: %s", err); return nil, errors.New("Invalid v5cID") }
-
-	if 				v5c_ID  == "" 	 ||
+	if 				v5cID  == "" 	 ||
 					matched == false    {
-																		fmt.Printf("CREATE_VEHICLE: Invalid v5cID provided");
-																		return nil, errors.New("Invalid v5cID provided=>"+v5cID+"<")
+																		return nil, new_error(ERR_INVALID_INPUT, "Invalid v5cID provided=>"+v5cID+"<").on_field("asset.assetID")
 	}
 
-	err = json.Unmarshal([]byte(vehicle_json), &v)							// Convert the JSON defined above into a vehicle object for go
-
-																		if err != nil { return nil, errors.New("Invalid JSON object") }
-
 	record, err := stub.GetState(v.V5cID) 								// If not an error then a record exists so cant create a new *** with this V5cID as it must be unique
 
-																		if record != nil { return nil, errors.New("Vehicle already exists") }
+																		if record != nil { return nil, new_error(ERR_ALREADY_EXISTS, "Vehicle already exists") }
 
-	if 	caller_affiliation != AUTHORITY {							// Only the regulator can create a new v5c
+	if 	caller_affiliation != REGULATOR {							// Only the regulator can create a new v5c
 
-		return nil, errors.New(fmt.Sprintf("Permission Denied. create_vehicle. %v === %v", caller_affiliation, AUTHORITY))
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission Denied. create_vehicle. %v === %v", caller_affiliation, REGULATOR))
 
 	}
 
-	_, err  = t.save_changes(stub, v)
-
-																		if err != nil { fmt.Printf("CREATE_VEHICLE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	bytes, err := stub.GetState("v5cIDs")
-
-																		if err != nil { return nil, errors.New("Unable to get v5cIDs") }
+	_, err  = t.save_changes(stub, v, caller, "create_vehicle")
 
-	var v5cIDs V5C_Holder
+																		if err != nil { return nil, wrap_error("Error saving changes", err) }
 
-	err = json.Unmarshal(bytes, &v5cIDs)
+	created, err := t.tx_time(stub)
 
-																		if err != nil {	return nil, errors.New("Corrupt V5C_Holder record") }
+															if err != nil { return nil, err }
 
-	v5cIDs.V5Cs = append(v5cIDs.V5Cs, v5cID)
+	err = t.add_asset_index(stub, v5cID, created.Format(time.RFC3339))
 
-
-	bytes, err = json.Marshal(v5cIDs)
-
-															if err != nil { fmt.Print("Error creating V5C_Holder record") }
-
-	err = stub.PutState("v5cIDs", bytes)
-
-															if err != nil { return nil, errors.New("Unable to put the state") }
+															if err != nil { return nil, err }
 
 	return nil, nil
 
 }
 
 //=================================================================================================================================
-//	 Create Asset - Creates the initial JSON for the asset and then saves it to the ledger.
+//	 new_asset - Returns the Vehicle createAsset makes of the request passed, an asset of the type def defines. Assets
+//				 are trucks unless the request asks for a car, see new_car, or a registered type.
 //=================================================================================================================================
-func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, v5cID string,animals Animal) ([]byte, error) {
-	var v Vehicle
+func new_asset(v5cID string, caller string, animals Animal, def AssetTypeDefinition) Vehicle {
 
-	//Initialize variables which will make up the JSON structure that will be written to world state
-	/*	TransactionType 	:= "\"transactionType\":\"UNDEFINED\", "
-		OwnerId				:= "\"ownerId\":\""+REGULATOR+"\", "			//owner at the time of creation is always regulator
-		AssetId				:= "\"assetID\":\""+v5cID+"\", "				//NOTE:assetId changed to assetID based on UI developer request
-		MatnrAf				:= "\"matnrAf\":\"UNDEFINED\", "
-		PoDma				:= "\"poDma\":\"UNDEFINED\", "
-		PoSupp				:= "\"poSupp\":\"UNDEFINED\", "
-		DmaDelDate			:= "\"dmaDelDate\":\"UNDEFINED\", "
-		AfDelDate			:= "\"afDelDate\":\"UNDEFINED\", "
-		TruckMod			:= "\"truckMod\":\"UNDEFINED\", "
-		TruckPDate			:= "\"truckPdate\":\"UNDEFINED\", "
-		TruckChnum			:= "\"truckChnum\":\"UNDEFINED\", "
-		TruckEnnum			:= "\"truckEnnum\":\"UNDEFINED\", "
-		SuppTest			:= "\"suppTest\":\"UNDEFINED\", "
-		GrDma				:= "\"grDma\":\"UNDEFINED\", "
-		GrAf				:= "\"grAf\":\"UNDEFINED\", "
-		DmaMasdat			:= "\"dmaMasdat\":\"UNDEFINED\", "
-		AfDmaTest			:= "\"afDmaTest\":\"UNDEFINED\", "
-		DmaDelCert			:= "\"dmaDelCert\":\"UNDEFINED\", "
-		AfDoc				:= "\"afDoc\":\"UNDEFINED\", "
-		Caller				:= "\"caller\":\"UNDEFINED\" "
-		v5c_ID         		:= "\"v5cID\":\""+v5cID+"\", "							// Variables to define the JSON
-	*/
-
-		TransactionType 	:= "\"transactionType\":\""+animals.TransactionType +"\", "
-		//OwnerId				:= "\"ownerId\":\""+REGULATOR+"\", "			//owner at the time of creation is always regulator. NOTE: this may have to change
-		OwnerId				:= "\"ownerId\":\""+animals.OwnerId+"\", "			//owner at the time of creation is always regulator. NOTE: this may have to change
-		AssetId				:= "\"assetID\":\""+v5cID+"\", "				//NOTE:assetId changed to assetID based on UI developer request
-		MatnrAf				:= "\"matnrAf\":\""+ animals.MatnrAf +"\", "
-		PoDma				:= "\"poDma\":\""+ animals.PoDma +"\", "
-		PoSupp				:= "\"poSupp\":\""+ animals.PoSupp +"\", "
-		DmaDelDate			:= "\"dmaDelDate\":\""+ animals.DmaDelDate +"\", "
-		AfDelDate			:= "\"afDelDate\":\""+ animals.AfDelDate +"\", "
-		TruckMod			:= "\"truckMod\":\""+ animals.TruckMod +"\", "
-		TruckPDate			:= "\"truckPdate\":\""+ animals.TruckPDate +"\", "
-		TruckChnum			:= "\"truckChnum\":\""+ animals.TruckChnum +"\", "
-		TruckEnnum			:= "\"truckEnnum\":\""+ animals.TruckEnnum +"\", "
-		SuppTest			:= "\"suppTest\":\""+ animals.SuppTest +"\", "
-		GrDma				:= "\"grDma\":\""+ animals.GrDma +"\", "
-		GrAf				:= "\"grAf\":\""+ animals.GrAf +"\", "
-		DmaMasdat			:= "\"dmaMasdat\":\""+ animals.DmaMasdat +"\", "
-		AfDmaTest			:= "\"afDmaTest\":\""+ animals.AfDmaTest +"\", "
-		DmaDelCert			:= "\"dmaDelCert\":\""+ animals.DmaDelCert +"\", "
-		AfDoc				:= "\"afDoc\":\""+ animals.AfDoc +"\", "
-		Caller				:= "\"caller\":\""+ "" +"\""							//leaving caller blank for now
-		v5c_ID         		:= "\"v5cID\":\""+v5cID+"\", "							// Variables to define the JSON
-
-	vehicle_json := "{"+v5c_ID+TransactionType+OwnerId+AssetId+MatnrAf+PoDma+PoSupp+DmaDelDate+AfDelDate+TruckMod+TruckPDate+TruckChnum+TruckEnnum+SuppTest+GrDma+GrAf+DmaMasdat+AfDmaTest+DmaDelCert+AfDoc+Caller+"}" 	// Concatenates the variables to create the total JSON object
-
-	//matched, err := regexp.Match("^[A-z][A-z][0-9]{7}", []byte(v5cID))  				// matched = true if the v5cID passed fits format of two letters followed by seven digits
-	
-	//NOTE: format check changed as per request from SAP and UI developers
-	// NOW matched = true if the v5cID passed fits format: 10 numeric digits
-	matched, err := regexp.Match("^[0-9]{10}", []byte(v5cID))  				
-		
-												if err != nil { fmt.Printf("CREATE_VEHICLE: Invalid v5cID: %s", err); return nil, errors.New("Invalid v5cID") }
-
-	if 				v5c_ID  == "" 	 ||
-					matched == false    {
-																		fmt.Printf("CREATE_VEHICLE: Invalid v5cID provided");
-																		return nil, errors.New("Invalid v5cID provided=>"+v5cID+"<")
-	}
-
-	err = json.Unmarshal([]byte(vehicle_json), &v)							// Convert the JSON defined above into a vehicle object for go
-
-																		if err != nil { return nil, errors.New("Invalid JSON object") }
-
-	record, err := stub.GetState(v.V5cID) 								// If not an error then a record exists so cant create a new *** with this V5cID as it must be unique
-
-																		if record != nil { return nil, errors.New("Vehicle already exists") }
-
-	if 	caller_affiliation != AUTHORITY {							// Only the regulator can create a new v5c
-
-		return nil, errors.New(fmt.Sprintf("Permission Denied. create_vehicle. %v === %v", caller_affiliation, AUTHORITY))
+	var v Vehicle
 
+	switch def.Name {
+	case ASSET_CAR:
+		v = new_car(v5cID, caller, animals)
+	case ASSET_TRUCK:
+		v = new_truck(v5cID, animals)
+	default:
+		v = def.new_asset(v5cID, caller, animals)
 	}
 
-	_, err  = t.save_changes(stub, v)
-
-																		if err != nil { fmt.Printf("CREATE_VEHICLE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	bytes, err := stub.GetState("v5cIDs")
-
-																		if err != nil { return nil, errors.New("Unable to get v5cIDs") }
-
-	var v5cIDs V5C_Holder
-
-	err = json.Unmarshal(bytes, &v5cIDs)
-
-																		if err != nil {	return nil, errors.New("Corrupt V5C_Holder record") }
-
-	v5cIDs.V5Cs = append(v5cIDs.V5Cs, v5cID)
-
-
-	bytes, err = json.Marshal(v5cIDs)
-
-															if err != nil { fmt.Print("Error creating V5C_Holder record") }
-
-	err = stub.PutState("v5cIDs", bytes)
-
-															if err != nil { return nil, errors.New("Unable to put the state") }
-
-	return nil, nil
+	v.Attributes = def.attribute_values(animals.Attributes)
 
+	return v
 }
 
 //=================================================================================================================================
-//	 Update Asset - Updates the initial JSON for the asset and then saves it to the ledger.
+//	 new_truck - Returns the truck createAsset makes of the request passed.
 //=================================================================================================================================
-func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string, animals Animal) ([]byte, error) {
+func new_truck(v5cID string, animals Animal) Vehicle {
 
-//if the transaction is fired by a person who owns this asset then he has the right to update
-	if 	v.OwnerId == animals.Caller		{
-				
-
-					if 	animals.TransactionType			!= ""	{ v.TransactionType = animals.TransactionType 	} 	
-					if	animals.OwnerId 				!= ""	{ v.OwnerId = animals.OwnerId					} 
-					
-					if	animals.MatnrAf					!= "" 	{ v.MatnrAf = animals.MatnrAf					}
-					if	animals.PoDma					!= ""	{ v.PoDma = animals.PoDma						}
-					if	animals.PoSupp					!= ""	{ v.PoSupp = animals.PoSupp						}
-					if	animals.DmaDelDate				!= ""	{ v.DmaDelDate = animals.DmaDelDate				}	
-					if	animals.AfDelDate				!= "" 	{ v.AfDelDate = animals.AfDelDate				}
-					if	animals.TruckMod				!= ""	{ v.TruckMod = animals.TruckMod					}
-					if	animals.TruckPDate				!= ""	{ v.TruckPDate = animals.TruckPDate				}
-					if	animals.TruckChnum				!= ""	{ v.TruckChnum = animals.TruckChnum				}	
-					if	animals.TruckEnnum				!= ""	{ v.TruckEnnum = animals.TruckEnnum				}	
-					if	animals.SuppTest				!= "" 	{ v.SuppTest = animals.SuppTest					}
-					if	animals.GrDma					!= ""	{ v.GrDma = animals.GrDma						}
-					if	animals.GrAf					!= "" 	{ v.GrAf = animals.GrAf							}
-					if	animals.DmaMasdat				!= ""	{ v.DmaMasdat = animals.DmaMasdat				}	
-					if	animals.AfDmaTest				!= ""	{ v.AfDmaTest = animals.AfDmaTest				}
-					if 	animals.DmaDelCert				!= "" 	{ v.DmaDelCert = animals.DmaDelCert				}	
-					if	animals.AfDoc					!= "" 	{ v.AfDoc = animals.AfDoc						}
-					//if	animals.Make					!= "" 	{ v.Make = animals.Make							}            	
-					if 	animals.Caller					!= ""	{ v.Caller = animals.Caller						}
-
-
-			} else {
-
-		return nil, errors.New("Permission denied caller and owner not same. updateAsset caller:" + animals.Caller + "ownerID:" + v.OwnerId )
+	return Vehicle{
+		V5cID:				v5cID,
+		AssetId:			v5cID,					//NOTE:assetId changed to assetID based on UI developer request
+		TransactionType:	animals.TransactionType,
+		OwnerId:			animals.OwnerId,		//owner at the time of creation is always regulator. NOTE: this may have to change
+		MatnrAf:			animals.MatnrAf,
+		PoDma:				animals.PoDma,
+		PoSupp:				animals.PoSupp,
+		DmaDelDate:			animals.DmaDelDate,
+		AfDelDate:			animals.AfDelDate,
+		TruckMod:			animals.TruckMod,
+		TruckPDate:			animals.TruckPDate,
+		TruckChnum:			animals.TruckChnum,
+		TruckEnnum:			animals.TruckEnnum,
+		SuppTest:			animals.SuppTest,
+		GrDma:				animals.GrDma,
+		GrAf:				animals.GrAf,
+		DmaMasdat:			animals.DmaMasdat,
+		AfDmaTest:			animals.AfDmaTest,
+		DmaDelCert:			animals.DmaDelCert,
+		AfDoc:				"",						//afDoc is a read-only view, documents are uploaded with addDoc
+		Status:				STATUS_PO_CREATED,		//every truck starts its lifecycle with the PO
+		Caller:				"",						//leaving caller blank for now
+		AssetType:			ASSET_TRUCK,
 	}
-			v.AssetId = v.V5cID	//assetId and v5cid are the same thing. 					
-
-	_, err := t.save_changes(stub, v)
-
-		if err != nil { fmt.Printf("updateAsset: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	return nil, nil
-
 }
 
-
-/* COMMENTED the vehicle "update" functions as of 7/26/2017 6:00PM
-
-//=================================================================================================================================
-//	 Transfer Functions
 //=================================================================================================================================
-//	 authority_to_manufacturer
+//	 check_new_asset - Runs the checks createAsset makes before writing the asset passed, without writing anything.
 //=================================================================================================================================
-func (t *SimpleChaincode) authority_to_manufacturer(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error) {
-
-	if     	v.Status				== STATE_TEMPLATE	&&
-			v.Owner					== caller			&&
-			caller_affiliation		== AUTHORITY		&&
-			recipient_affiliation	== MANUFACTURER		&&
-			v.Scrapped				== false			{		// If the roles and users are ok
-
-					v.Owner  = recipient_name		// then make the owner the new owner
-					v.Status = STATE_MANUFACTURE			// and mark it in the state of manufacture
-
-	} else {									// Otherwise if there is an error
-															fmt.Printf("AUTHORITY_TO_MANUFACTURER: Permission Denied");
-                                                            return nil, errors.New(fmt.Sprintf("Permission Denied. authority_to_manufacturer. %v %v === %v, %v === %v, %v === %v, %v === %v, %v === %v", v, v.Status, STATE_PRIVATE_OWNERSHIP, v.Owner, caller, caller_affiliation, PRIVATE_ENTITY, recipient_affiliation, SCRAP_MERCHANT, v.Scrapped, false))
-
+func (t *SimpleChaincode) check_new_asset(stub shim.ChaincodeStubInterface, v Vehicle, animals Animal, def AssetTypeDefinition, function string) error {
 
-	}
+	//NOTE: format check changed as per request from SAP and UI developers
+	// the v5cID passed must fit the deployment's asset ID format, by default 10 numeric digits (see asset_id_pattern)
+	settings, err := t.retrieve_settings(stub)
 
-	_, err := t.save_changes(stub, v)						// Write new state
+															if err != nil { return err }
 
-															if err != nil {	fmt.Printf("AUTHORITY_TO_MANUFACTURER: Error saving changes: %s", err); return nil, errors.New("Error saving changes")	}
+	err = settings.check_asset_id(v.V5cID)
 
-	return nil, nil									// We are Done
+															if err != nil { return err }
 
-}
+	record, err := stub.GetState(v.V5cID) 								// If not an error then a record exists so cant create a new *** with this V5cID as it must be unique
 
-//=================================================================================================================================
-//	 manufacturer_to_private
-//=================================================================================================================================
-func (t *SimpleChaincode) manufacturer_to_private(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error) {
-
-	if 		v.Make 	 == "UNDEFINED" ||
-			v.Model  == "UNDEFINED" ||
-			v.Reg 	 == "UNDEFINED" ||
-			v.Colour == "UNDEFINED" ||
-			v.VIN == 0				{					//If any part of the *** is undefined it has not bene fully manufacturered so cannot be sent
-															fmt.Printf("MANUFACTURER_TO_PRIVATE: Car not fully defined")
-															return nil, errors.New(fmt.Sprintf("Car not fully defined. %v", v))
-	}
+																		if record != nil { return new_error(ERR_ALREADY_EXISTS, "Vehicle already exists").on_field("asset.assetID") }
 
-	if 		v.Status				== STATE_MANUFACTURE	&&
-			v.Owner					== caller				&&
-			caller_affiliation		== MANUFACTURER			&&
-			recipient_affiliation	== PRIVATE_ENTITY		&&
-			v.Scrapped     == false							{
+	if 	animals.AfDoc != "" { return new_error(ERR_INVALID_INPUT, "afDoc is read-only, attach documents with addDoc or updateDoc").on_field("asset.afDoc") }
 
-					v.Owner = recipient_name
-					v.Status = STATE_PRIVATE_OWNERSHIP
+	if 	errs := foreign_fields(v.asset_type(), animals.as_vehicle()); len(errs) > 0 { return invalid_input(function, "fields of another asset type", errs) }
 
-	} else {
-        return nil, errors.New(fmt.Sprintf("Permission Denied. manufacturer_to_private. %v %v === %v, %v === %v, %v === %v, %v === %v, %v === %v", v, v.Status, STATE_PRIVATE_OWNERSHIP, v.Owner, caller, caller_affiliation, PRIVATE_ENTITY, recipient_affiliation, SCRAP_MERCHANT, v.Scrapped, false))
-    }
+	if 	errs := def.check_attributes(animals.Attributes, "asset." + ATTRIBUTE_PREFIX, true); len(errs) > 0 { return invalid_input(function, "Invalid attributes", errs) }
 
-	_, err := t.save_changes(stub, v)
+	err = t.check_lookups(stub, Vehicle{}, v)
 
-	if err != nil { fmt.Printf("MANUFACTURER_TO_PRIVATE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	return nil, nil
+															if err != nil { return err }
 
+	return t.validate_dates(stub, v, function)
 }
 
 //=================================================================================================================================
-//	 private_to_private
+//	 Create Asset - Creates the initial JSON for the asset and then saves it to the ledger. If no assetID is passed and
+//					the deployment allocates asset IDs, the asset gets the next one of the sequence. Returns the
+//					asset's ID.
 //=================================================================================================================================
-func (t *SimpleChaincode) private_to_private(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error) {
+func (t *SimpleChaincode) createAsset(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string, v5cID string,animals Animal) ([]byte, error) {
 
-	if 		v.Status				== STATE_PRIVATE_OWNERSHIP	&&
-			v.Owner					== caller					&&
-			caller_affiliation		== PRIVATE_ENTITY			&&
-			recipient_affiliation	== PRIVATE_ENTITY			&&
-			v.Scrapped				== false					{
+	if 	caller_affiliation != REGULATOR {							// Only the regulator can create a new v5c
 
-					v.Owner = recipient_name
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission Denied. createAsset. %v === %v", caller_affiliation, REGULATOR))
 
-	} else {
-        return nil, errors.New(fmt.Sprintf("Permission Denied. private_to_private. %v %v === %v, %v === %v, %v === %v, %v === %v, %v === %v", v, v.Status, STATE_PRIVATE_OWNERSHIP, v.Owner, caller, caller_affiliation, PRIVATE_ENTITY, recipient_affiliation, SCRAP_MERCHANT, v.Scrapped, false))
 	}
 
-	_, err := t.save_changes(stub, v)
+	settings, err := t.retrieve_settings(stub)
 
-															if err != nil { fmt.Printf("PRIVATE_TO_PRIVATE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+															if err != nil { return nil, err }
 
-	return nil, nil
+	seq, err := t.retrieve_id_sequence(stub)
 
-}
+															if err != nil { return nil, err }
 
-//=================================================================================================================================
-//	 private_to_lease_company
-//=================================================================================================================================
-func (t *SimpleChaincode) private_to_lease_company(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error) {
+	allocated := v5cID == ""
 
-	if 		v.Status				== STATE_PRIVATE_OWNERSHIP	&&
-			v.Owner					== caller					&&
-			caller_affiliation		== PRIVATE_ENTITY			&&
-			recipient_affiliation	== LEASE_COMPANY			&&
-            v.Scrapped     			== false					{
+	v5cID, err = t.resolve_asset_id(stub, settings, v5cID, &seq, nil)
 
-					v.Owner = recipient_name
+															if err != nil { return nil, err }
 
-	} else {
-        return nil, errors.New(fmt.Sprintf("Permission denied. private_to_lease_company. %v === %v, %v === %v, %v === %v, %v === %v, %v === %v", v.Status, STATE_PRIVATE_OWNERSHIP, v.Owner, caller, caller_affiliation, PRIVATE_ENTITY, recipient_affiliation, SCRAP_MERCHANT, v.Scrapped, false))
+	def, err := t.asset_definition(stub, animals.AssetType)
 
-	}
+															if err != nil { return nil, err }
 
-	_, err := t.save_changes(stub, v)
-															if err != nil { fmt.Printf("PRIVATE_TO_LEASE_COMPANY: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	v := new_asset(v5cID, caller, animals, def)
 
-	return nil, nil
+	err = t.check_new_asset(stub, v, animals, def, "createAsset")
 
-}
+															if err != nil { return nil, err }
 
-//=================================================================================================================================
-//	 lease_company_to_private
-//=================================================================================================================================
-func (t *SimpleChaincode) lease_company_to_private(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error) {
+	_, err  = t.save_changes(stub, v, caller, "createAsset")
 
-	if		v.Status				== STATE_PRIVATE_OWNERSHIP	&&
-			v.Owner  				== caller					&&
-			caller_affiliation		== LEASE_COMPANY			&&
-			recipient_affiliation	== PRIVATE_ENTITY			&&
-			v.Scrapped				== false					{
+																		if err != nil { return nil, wrap_error("Error saving changes", err) }
 
-				v.Owner = recipient_name
+	created, err := t.tx_time(stub)
 
-	} else {
-		return nil, errors.New(fmt.Sprintf("Permission Denied. lease_company_to_private. %v %v === %v, %v === %v, %v === %v, %v === %v, %v === %v", v, v.Status, STATE_PRIVATE_OWNERSHIP, v.Owner, caller, caller_affiliation, PRIVATE_ENTITY, recipient_affiliation, SCRAP_MERCHANT, v.Scrapped, false))
-	}
+															if err != nil { return nil, err }
 
-	_, err := t.save_changes(stub, v)
-															if err != nil { fmt.Printf("LEASE_COMPANY_TO_PRIVATE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	err = t.add_asset_index(stub, v5cID, created.Format(time.RFC3339))
 
-	return nil, nil
-
-}
-
-//=================================================================================================================================
-//	 private_to_scrap_merchant
-//=================================================================================================================================
-func (t *SimpleChaincode) private_to_scrap_merchant(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, recipient_name string, recipient_affiliation string) ([]byte, error) {
+															if err != nil { return nil, err }
 
-	if		v.Status				== STATE_PRIVATE_OWNERSHIP	&&
-			v.Owner					== caller					&&
-			caller_affiliation		== PRIVATE_ENTITY			&&
-			recipient_affiliation	== SCRAP_MERCHANT			&&
-			v.Scrapped				== false					{
+	if 	allocated {
 
-					v.Owner = recipient_name
-					v.Status = STATE_BEING_SCRAPPED
+		err = t.save_id_sequence(stub, seq)
 
-	} else {
-        return nil, errors.New(fmt.Sprintf("Permission Denied. private_to_scrap_merchant. %v %v === %v, %v === %v, %v === %v, %v === %v, %v === %v", v, v.Status, STATE_PRIVATE_OWNERSHIP, v.Owner, caller, caller_affiliation, PRIVATE_ENTITY, recipient_affiliation, SCRAP_MERCHANT, v.Scrapped, false))
+															if err != nil { return nil, err }
 	}
 
-	_, err := t.save_changes(stub, v)
-
-															if err != nil { fmt.Printf("PRIVATE_TO_SCRAP_MERCHANT: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	return nil, nil
+	return json.Marshal(struct {
+		AssetId string `json:"assetID"`
+	}{v5cID})
 
 }
 
 //=================================================================================================================================
-//	 Update Functions
+//	 Update Asset - Updates the initial JSON for the asset and then saves it to the ledger. Every field the request
+//					changes is checked against the permission matrix of the asset's type (see field_permissions and
+//					permission_matrix), so each participant can record its own steps whether or not it owns the asset.
 //=================================================================================================================================
-//	 update_vin
-//=================================================================================================================================
-func (t *SimpleChaincode) update_vin(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string) ([]byte, error) {
-
-	new_vin, err := strconv.Atoi(string(new_value)) 		                // will return an error if the new vin contains non numerical chars
-
-															if err != nil || len(string(new_value)) != 15 { return nil, errors.New("Invalid value passed for new VIN") }
-
-	if 		v.Status			== STATE_MANUFACTURE	&&
-			v.Owner				== caller				&&
-			caller_affiliation	== MANUFACTURER			&&
-			v.VIN				== 0					&&			// Can't change the VIN after its initial assignment
-			v.Scrapped			== false				{
-
-					v.VIN = new_vin					// Update to the new value
-	} else {
+func (t *SimpleChaincode) updateAsset(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string, animals Animal) ([]byte, error) {
 
-        return nil, errors.New(fmt.Sprintf("Permission denied. update_vin %v %v %v %v %v", v.Status, STATE_MANUFACTURE, v.Owner, caller, v.VIN, v.Scrapped))
+	v, err := t.apply_update(stub, v, caller, caller_affiliation, animals, "updateAsset")
 
-	}
+		if err != nil { return nil, err }
 
-	_, err  = t.save_changes(stub, v)						// Save the changes in the blockchain
+	_, err = t.save_changes(stub, v, caller, "updateAsset")
 
-															if err != nil { fmt.Printf("UPDATE_VIN: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+		if err != nil { return nil, wrap_error("Error saving changes", err) }
 
 	return nil, nil
 
 }
 
-
 //=================================================================================================================================
-//	 update_registration
+//	 apply_update - Runs the checks of updateAsset on the request passed and returns v with its changes applied,
+//					without writing anything. function names the chaincode function in errors.
 //=================================================================================================================================
-func (t *SimpleChaincode) update_registration(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string) ([]byte, error) {
-
+func (t *SimpleChaincode) apply_update(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, animals Animal, function string) (Vehicle, error) {
 
-	if		v.Owner				== caller			&&
-			caller_affiliation	!= SCRAP_MERCHANT	&&
-			v.Scrapped			== false			{
+	if animals.Status != "" && animals.Status != asset_status(v) { return v, new_error(ERR_INVALID_INPUT, function + " can not change status " + asset_status(v) + ", use transitionAsset").on_field("asset.status") }
 
-					v.Reg = new_value
+	def, err := t.asset_definition(stub, v.asset_type())
 
-	} else {
-        return nil, errors.New(fmt.Sprint("Permission denied. update_registration"))
-	}
+		if err != nil { return v, err }
 
-	_, err := t.save_changes(stub, v)
+	if 	errs := def.check_attributes(animals.Attributes, "asset." + ATTRIBUTE_PREFIX, false); len(errs) > 0 { return v, invalid_input(function, "Invalid attributes", errs) }
 
-															if err != nil { fmt.Printf("UPDATE_REGISTRATION: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	update := def.as_vehicle(animals)
+	changed := changed_fields(v, update)
+	forbidden := forbidden_fields(changed, v, caller, caller_affiliation, def.permission_matrix())
 
-	return nil, nil
+//if the caller may write every field the request changes then he has the right to update
+	if 	len(forbidden) == 0		{
 
-}
+					apply_fields(&v, update, changed)
 
-//=================================================================================================================================
-//	 update_colour
-//=================================================================================================================================
-func (t *SimpleChaincode) update_colour(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string) ([]byte, error) {
+					if 	caller							!= ""	{ v.Caller = caller								}
 
-	if 		v.Owner				== caller				&&
-			caller_affiliation	== MANUFACTURER			&&/*((v.Owner				== caller			&&
-			caller_affiliation	== MANUFACTURER)		||
-			caller_affiliation	== AUTHORITY)			&&*/
-/*			v.Scrapped			== false				{
 
-					v.Colour = new_value
 	} else {
 
-		return nil, errors.New(fmt.Sprint("Permission denied. update_colour %t %t %t" + v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
+		return v, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. %v caller:%v role:%v status:%v may not write fields: %v", function, caller, caller_affiliation, asset_status(v), strings.Join(forbidden, ", ")))
 	}
+			v.AssetId = v.V5cID	//assetId and v5cid are the same thing. 					
 
-	_, err := t.save_changes(stub, v)
-
-		if err != nil { fmt.Printf("UPDATE_COLOUR: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+	err = t.validate_dates(stub, v, function)
 
-	return nil, nil
+	return v, err
 
 }
 
-//=================================================================================================================================
-//	 update_make
-//=================================================================================================================================
-func (t *SimpleChaincode) update_make(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string) ([]byte, error) {
-
-	if 		v.Status			== STATE_MANUFACTURE	&&
-			v.Owner				== caller				&&
-			caller_affiliation	== MANUFACTURER			&&
-			v.Scrapped			== false				{
-
-					v.Make = new_value
-	} else {
-
-        return nil, errors.New(fmt.Sprint("Permission denied. update_make %t %t %t" + v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
-
-
-	}
-
-	_, err := t.save_changes(stub, v)
-
-															if err != nil { fmt.Printf("UPDATE_MAKE: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	return nil, nil
-
-}
 
 //=================================================================================================================================
-//	 update_model
+//	 Read Functions
+//=================================================================================================================================
+//	 get_vehicle_details
 //=================================================================================================================================
-func (t *SimpleChaincode) update_model(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, new_value string) ([]byte, error) {
+func (t *SimpleChaincode) get_vehicle_details(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, format string) ([]byte, error) {
 
-	if 		v.Status			== STATE_MANUFACTURE	&&
-			v.Owner				== caller				&&
-			caller_affiliation	== MANUFACTURER			&&
-			v.Scrapped			== false				{
-
-					v.Model = new_value
-
-	} else {
-        return nil, errors.New(fmt.Sprint("Permission denied. update_model %t %t %t" + v.Owner == caller, caller_affiliation == MANUFACTURER, v.Scrapped))
+	if 		v.OwnerId	!= caller		&&
+				caller_affiliation  != REGULATOR	{
 
+				return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readAsset. The caller should be owner or Regulator.")
 	}
 
-	_, err := t.save_changes(stub, v)
-
-															if err != nil { fmt.Printf("UPDATE_MODEL: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
-
-	return nil, nil
-
-}
-
-//=================================================================================================================================
-//	 scrap_vehicle
-//=================================================================================================================================
-func (t *SimpleChaincode) scrap_vehicle(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
-
-	if		v.Status			== STATE_BEING_SCRAPPED	&&
-			v.Owner				== caller				&&
-			caller_affiliation	== SCRAP_MERCHANT		&&
-			v.Scrapped			== false				{
-
-					v.Scrapped = true
+	v.Caller = caller //update the vehicle's caller with the userID who called
 
-	} else {
-		return nil, errors.New("Permission denied. scrap_vehicle")
-	}
+	afDoc, err := t.afdoc_view(stub, v)
 
-	_, err := t.save_changes(stub, v)
+	if err != nil { return nil, err }
 
-															if err != nil { fmt.Printf("SCRAP_VEHICLE: Error saving changes: %s", err); return nil, errors.New("SCRAP_VEHICLError saving changes") }
+	v.AfDoc = afDoc //afDoc is a read-only view of the latest document
 
-	return nil, nil
+	//only the vehicle struct is stored on the blockchain, the envelope
+	//is the shape the UI expects and is added on the way out.
+	return t.render_asset(stub, v.V5cID, v, format)
 
 }
-*/
-//=================================================================================================================================
-//	 Read Functions
+
 //=================================================================================================================================
-//	 get_vehicle_details
+//	 get_vehicle_details2 - As get_vehicle_details without the afDoc view, for functions returning many assets.
 //=================================================================================================================================
-func (t *SimpleChaincode) get_vehicle_details(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
+func (t *SimpleChaincode) get_vehicle_details2(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, format string) ([]byte, error) {
 
+	if 		v.OwnerId	!= caller		&&
+				caller_affiliation  != REGULATOR	{
 
-	v.Caller = caller //update the vehicle's caller with the userID who called
-	bytes1, err := json.Marshal(v)
-	
-	
-	//make up the response in such a manner to have the response sandwiched
-	//between mspart1 and msgpart2. this is done to reformat the response 
-	//which the UI expects. The data stored on the blockchain will have no change 
-	//in structure. only the vehicle struct will be stored on blockchain. 
-	//This reformat is just an adjustment.
-
-	var str bytes.Buffer
-	
-	str1 := string(bytes1)
-	msgpart1  := "{\"assetstate\":{\"asset\":"
-	
-
-	txnID := stub.GetTxID()
-	txntmsp,errN := stub.GetTxTimestamp()
-	
-	timetemp1 := time.Unix(txntmsp.Seconds, int64(txntmsp.Nanos))
-	time1 := timetemp1.Format(time.RFC3339)	
-	//time1 := timetemp.String()
-	_ = errN
-	
-	
-    
-	msgpart2  := "},\"txnid\":\""
-	msgpart3  := "\",\"txnts\":\""
-	msgpart4  := "\"}" //txnid and txnts to be populated
-	
-	str.WriteString(msgpart1)
-	str.WriteString(str1)
-	str.WriteString(msgpart2)
-	str.WriteString(txnID)
-	str.WriteString(msgpart3)
-	str.WriteString(time1)
-	str.WriteString(msgpart4)
-	
-	
-	bytes3 := []byte(str.String())
-
-	
-	
-	if err != nil { return nil, errors.New("READASSET: Invalid vehicle object") }
-
-	if 		v.OwnerId	== caller		||
-				caller  == REGULATOR	{
-
-					//return bytes, nil
-					return bytes3, nil
-	} else {
-				return nil, errors.New("Permission Denied. readAsset. The caller should be owner or Regulator.")
+				return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readAsset. The caller should be owner or Regulator.")
 	}
 
-}
-
-
-func (t *SimpleChaincode) get_vehicle_details2(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
-
 	//blank out v.AfDoc. Because readAllAssets will have huge response incase the asset has documents attached
 	v.AfDoc = ""
 
-	bytes1, err := json.Marshal(v)
-	
-	//make up the response in such a manner to have the response sandwiched
-	//between mspart1 and msgpart2. this is done to reformat the response 
-	//which the UI expects. The data stored on the blockchain will have no change 
-	//in structure. only the vehicle struct will be stored on blockchain. 
-	//This reformat is just an adjustment.
-
-	var str bytes.Buffer
-	
-	str1 := string(bytes1)
-	/*
-	msgpart1  := "{\"assetstate\":{{\"asset\":"
-	msgpart2  := "}},\"txnid\":\"\",\"txnts\":\"\"}" //txnid and txnts to be populated
-
-	str.WriteString(msgpart1)
-	str.WriteString(str1)
-	str.WriteString(msgpart2)
-	*/
-	msgpart1  := "{\"assetstate\":{\"asset\":"
-	
-
-	txnID := stub.GetTxID()
-	txntmsp,errN := stub.GetTxTimestamp()
-	
-	//time1 := time.Unix(txntmsp.Seconds, int64(txntmsp.Nanos)).String()
-	//_ = errN
-	
-	timetemp1 := time.Unix(txntmsp.Seconds, int64(txntmsp.Nanos))
-	time1 := timetemp1.Format(time.RFC3339)	
-	//time1 := timetemp.String()
-	_ = errN
-	
-	
-	msgpart2  := "},\"txnid\":\""
-	msgpart3  := "\",\"txnts\":\""
-	msgpart4  := "\"}" //txnid and txnts to be populated
-	
-	str.WriteString(msgpart1)
-	str.WriteString(str1)
-	str.WriteString(msgpart2)
-	str.WriteString(txnID)
-	str.WriteString(msgpart3)
-	str.WriteString(time1)
-	str.WriteString(msgpart4)
-	
-	
-	bytes3 := []byte(str.String())
-	
-	
-	if err != nil { return nil, errors.New("READASSET: Invalid vehicle object") }
-
-	if 		v.OwnerId	== caller		||
-				caller  == REGULATOR	{
-
-					//return bytes, nil
-					return bytes3, nil
-	} else {
-				return nil, errors.New("Permission Denied. readAsset. The caller should be owner or Regulator.")
-	}
+	return t.render_asset(stub, v.V5cID, v, format)
 
 }
 
@@ -1182,47 +752,38 @@ func (t *SimpleChaincode) get_vehicle_details2(stub shim.ChaincodeStubInterface,
 
 func (t *SimpleChaincode) get_vehicles(stub shim.ChaincodeStubInterface, caller string, caller_affiliation string) ([]byte, error) {
 
-	if 	caller  != REGULATOR	{
+	if 	caller_affiliation  != REGULATOR	{
 
- 			return nil, errors.New("Permission Denied! Only a REGULATOR may read list of all assets")
+ 			return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied! Only a REGULATOR may read list of all assets")
 	}
 
 
-	bytes, err := stub.GetState("v5cIDs")
+	result := []json.RawMessage{}
 
-	if err != nil { return nil, errors.New("Unable to get v5cIDs or assetIds") }
+	err := scan_entries(stub, ASSET_INDEX_PREFIX, "", func(e IndexEntry) (bool, error) {
 
-	var v5cIDs V5C_Holder
+		v, err := t.retrieve_v5c(stub, e.Key)
 
-	err = json.Unmarshal(bytes, &v5cIDs)
+		if _, unreadable := err.(ChaincodeError); unreadable { return true, nil }		// a car record not migrated yet or a corrupt record is left out
 
-	if err != nil {	return nil, errors.New("Corrupt V5C_Holder or AssetID Holder") }
+		if err != nil { return false, err }
 
-	result := "["
+		asset, err := t.get_vehicle_details2(stub, v, caller, caller_affiliation, FORMAT_LEGACY)
 
-	var temp []byte
-	var v Vehicle
-
-	for _, v5c := range v5cIDs.V5Cs {
-
-		v, err = t.retrieve_v5c(stub, v5c)
+		if err == nil {
+			result = append(result, asset)
+		}
 
-		if err != nil {return nil, errors.New("Failed to retrieve V5C or AssetId")}
+		return true, nil
+	})
 
-		temp, err = t.get_vehicle_details2(stub, v, caller, caller_affiliation)
+	if err != nil { return nil, err }
 
-		if err == nil {
-			result += string(temp) + ","
-		}
-	}
+	bytes, err := json.Marshal(result)
 
-	if len(result) == 1 {
-		result = "[]"
-	} else {
-		result = result[:len(result)-1] + "]"
-	}
+	if err != nil { return nil, errors.New("Error converting list of assets") }
 
-	return []byte(result), nil
+	return bytes, nil
 }
 
 //=================================================================================================================================
@@ -1231,98 +792,63 @@ func (t *SimpleChaincode) get_vehicles(stub shim.ChaincodeStubInterface, caller
 func (t *SimpleChaincode) check_unique_v5c(stub shim.ChaincodeStubInterface, v5c string, caller string, caller_affiliation string) ([]byte, error) {
 	_, err := t.retrieve_v5c(stub, v5c)
 	if err == nil {
-		return []byte("false"), errors.New("V5C or AssetId is not unique")
+		return []byte("false"), new_error(ERR_ALREADY_EXISTS, "V5C or AssetId is not unique").on_field("asset.assetID")
 	} else {
 		return []byte("true"), nil
 	}
 }
 
 //=================================================================================================================================
-//	 Update Doc - Attaches document to a blockchain block
+//	 Update Doc - Attaches the document passed in afDoc to the asset as a delivery certificate. Kept for clients
+//				  written before addDoc; the document goes to the document store like any other.
 //=================================================================================================================================
 func (t *SimpleChaincode) updateDoc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, animals Animal) ([]byte, error) {
 
-//if the transaction is fired by a person who owns this asset then he has the right to update
-	if 	v.OwnerId == animals.Caller		{
+	settings, err := t.retrieve_settings(stub)
+
+		if err != nil { return nil, err }
+
+//if the caller may upload delivery certificates for this asset then he has the right to update
+	if 	may_upload(DOC_DELIVERY_CERTIFICATE, v, caller, caller_affiliation)		{
 					
-					if	animals.AfDoc					== "" 	{ return nil, errors.New("AfDoc cannot be empty when updateDoc is called!")}
+					if	animals.AfDoc					== "" 	{ return nil, new_error(ERR_INVALID_INPUT, "AfDoc cannot be empty when updateDoc is called!").on_field("asset.afDoc")}
 
-					if  utf8.RuneCountInString(animals.AfDoc) > 250000 { return nil, errors.New("AfDoc cannot be larger than 250KB!")}
+					if  utf8.RuneCountInString(animals.AfDoc) > settings.MaxDocSize { return nil, new_error(ERR_TOO_LARGE, fmt.Sprintf("AfDoc cannot be larger than %v characters!", settings.MaxDocSize)).on_field("asset.afDoc")}
 
 					} else {
 
-		return nil, errors.New(fmt.Sprint("Permission denied. updateAsset %t %t" + v.OwnerId == caller, caller_affiliation == MANUFACTURER))
+		return nil, new_error(ERR_PERMISSION_DENIED, fmt.Sprintf("Permission denied. updateDoc caller:%v role:%v may not upload documents of type %v", caller, caller_affiliation, DOC_DELIVERY_CERTIFICATE))
 	}
-	
-	v.AssetId = v.V5cID	//assetId and v5cid are the same thing. 					
-	v.AfDoc = animals.AfDoc //move input to vehicle structure
+
+	content, mime, err := decode_content(animals.AfDoc)
+
+		if err != nil { return nil, err }
 
 	//Now post the document to blockchain
-	_, err := t.save_changes(stub, v)
+	_, err = t.store_doc(stub, v, caller, caller_affiliation, "updateDoc", DocRequest{DocType: DOC_DELIVERY_CERTIFICATE, FileName: "afDoc", MimeType: mime}, content)
 
-		if err != nil { fmt.Printf("updateAsset: Error saving changes: %s", err); return nil, errors.New("Error saving changes") }
+		if err != nil { return nil, wrap_error("Error storing document", err) }
 
 	return nil, nil
 
 }
 
 //=================================================================================================================================
-//	 Update Doc - Attaches document to a blockchain block
+//	 Read Doc - Returns the afDoc view of the asset, the content of its latest document.
 //=================================================================================================================================
-func (t *SimpleChaincode) readDoc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string) ([]byte, error) {
-
-
-	//if the transaction is fired by a person who owns this asset then he has the right to update
-	//if 	v.OwnerId == animals.Caller		{
-
-	bytes1, err := json.Marshal(v.AfDoc)
-	
-	var str bytes.Buffer
-	
-	str1 := string(bytes1)
-		msgpart1  := "{\"assetstate\":{\"asset\":"
-	
-
-	txnID := stub.GetTxID()
-	txntmsp,errN := stub.GetTxTimestamp()
-	
-	//time1 := time.Unix(txntmsp.Seconds, int64(txntmsp.Nanos)).String()
-	//_ = errN
-	timetemp1 := time.Unix(txntmsp.Seconds, int64(txntmsp.Nanos))
-	time1 := timetemp1.Format(time.RFC3339)	
-	//time1 := timetemp.String()
-	_ = errN
-	
-	msgpart2  := "},\"txnid\":\""
-	msgpart3  := "\",\"txnts\":\""
-	msgpart4  := "\"}" //txnid and txnts to be populated
-	
-	str.WriteString(msgpart1)
-	str.WriteString(str1)
-	str.WriteString(msgpart2)
-	str.WriteString(txnID)
-	str.WriteString(msgpart3)
-	str.WriteString(time1)
-	str.WriteString(msgpart4)
-	
-
-	bytes3 := []byte(str.String())
-
-	
-	
-	if err != nil { return nil, errors.New("READASSET: Invalid vehicle object") }
-
-	if 		v.OwnerId	== caller		||
-				caller  == REGULATOR	{
-
-					//return bytes, nil
-					return bytes3, nil
-	} else {
-				return nil, errors.New("Permission Denied. readAsset. The caller should be owner or Regulator.")
+func (t *SimpleChaincode) readDoc(stub shim.ChaincodeStubInterface, v Vehicle, caller string, caller_affiliation string, format string) ([]byte, error) {
+
+	if 		v.OwnerId	!= caller		&&
+				caller_affiliation  != REGULATOR	{
+
+				return nil, new_error(ERR_PERMISSION_DENIED, "Permission Denied. readDoc. The caller should be owner or Regulator.")
 	}
 
+	afDoc, err := t.afdoc_view(stub, v)
 
+	if err != nil { return nil, err }
 
+	return t.render_asset(stub, v.V5cID, afDoc, format)
 }
 
 
@@ -1330,8 +856,15 @@ func (t *SimpleChaincode) readDoc(stub shim.ChaincodeStubInterface, v Vehicle, c
 //=================================================================================================================================
 //	 Main - main - Starts up the chaincode
 //=================================================================================================================================
+//	 replay - Set when built with the replay tag, see replay.go. Replays the scenario files named on the command line
+//			  instead of starting the chaincode.
+//=================================================================================================================================
+var replay func(args []string) int
+
 func main() {
 
+	if replay != nil { os.Exit(replay(os.Args[1:])) }
+
 	err := shim.Start(new(SimpleChaincode))
 
 	if err != nil { fmt.Printf("Error starting Chaincode: %s", err) }